@@ -0,0 +1,61 @@
+// Command minifp is an interpreter for minifp.
+//
+// Usage:
+//
+//	minifp [flags]
+//	minifp run file.mfp [args...]
+//
+// Without arguments, it starts an interactive session. It reads expressions
+// and definitions from the standard input, and prints their values. Type
+// ":help" at the prompt for the list of commands. Only the first 1000
+// constructor values, e.g., list cells, of a result are evaluated and printed,
+// so an infinite list is printed as "0 : 1 : ... : 999 : ...".
+//
+// "minifp run" evaluates the main binding of a script, and prints its value. If
+// main is a function, it is applied to the arguments as a list of strings. The
+// exit status is 0 on success, 1 if the script cannot be read, 2 for a usage
+// error, 3 for a syntax error, 4 for a type or compile error, and 5 for a
+// runtime error. Error messages start with file:line:col.
+//
+// "import Foo" reads module Foo from file Foo.mfp. The file is searched for in
+// the directory of the script, or in the current directory in an interactive
+// session, then in the directories listed by the -path flag, which defaults to
+// $MINIFP_PATH.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+)
+
+func main() {
+	defaultHistory := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		defaultHistory = filepath.Join(home, ".minifp_history")
+	}
+	modulePath := flag.String("path", os.Getenv("MINIFP_PATH"),
+		"list of directories searched for imported modules, separated by "+string(filepath.ListSeparator))
+	historyFile := flag.String("history", defaultHistory, "file to keep the REPL input history in. Empty disables the history file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n       %s run file.mfp [args...]\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 0 {
+		if flag.Arg(0) != "run" || flag.NArg() < 2 {
+			flag.Usage()
+			os.Exit(exitUsage)
+		}
+		os.Exit(runScript(os.Stdout, os.Stderr, flag.Arg(1), flag.Args()[2:], filepath.SplitList(*modulePath)))
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	r := newREPL(os.Stdin, os.Stdout, append([]string{"."}, filepath.SplitList(*modulePath)...))
+	r.interrupt = interrupt
+	r.historyFile = *historyFile
+	r.run()
+}
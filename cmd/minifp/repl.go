@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+const (
+	prompt     = "minifp> "
+	contPrompt = "......> "
+	// maxHistory is the number of history entries kept. Entries are appended to
+	// the history file, and the file is rewritten with the last maxHistory
+	// entries once it holds twice as many.
+	maxHistory = 1000
+	// maxForce bounds the number of constructor values that are evaluated to
+	// print a result, so that printing an infinite list terminates.
+	maxForce = 1000
+)
+
+const replHelp = `Enter an expression to evaluate it, or a definition such as "f x = x + 1" to
+add a global. An incomplete expression continues on the next line; an empty
+line ends it. Commands:
+
+  :type EXPR       show the type of EXPR
+  :ast EXPR        show the parse tree of EXPR
+  :kcode EXPR      show the machine code of EXPR
+  :trace on|off    trace the machine execution
+  :load FILE       evaluate the definitions and expressions in FILE
+  :reset           forget all definitions
+  :history         show the input history
+  :help            show this message
+  :quit            exit
+`
+
+// repl is an interactive session. It keeps one machine across inputs, so the
+// globals defined by one input are visible to the later ones.
+type repl struct {
+	in  *bufio.Scanner
+	out io.Writer
+	// interrupt, if non-nil, aborts the current evaluation when it receives a
+	// value.
+	interrupt <-chan os.Signal
+	// historyFile, if non-empty, is the file that the history is loaded from and
+	// saved to.
+	historyFile string
+	history     []string
+	// historyLines is the number of entries in the history file.
+	historyLines int
+
+	// modulePath lists the directories searched for imported modules.
+	modulePath []string
+
+	km     *minifp.KMachine
+	tc     *minifp.TypeChecker
+	loader *minifp.ModuleLoader
+	trace  bool
+}
+
+func newREPL(in io.Reader, out io.Writer, modulePath []string) *repl {
+	r := &repl{in: bufio.NewScanner(in), out: out, modulePath: modulePath}
+	r.reset()
+	return r
+}
+
+// reset creates a fresh machine, type checker, and module loader.
+func (r *repl) reset() {
+	r.loader = minifp.NewModuleLoader(r.modulePath...)
+	r.km = minifp.NewMachine(minifp.MaxForce(maxForce))
+	r.tc = minifp.NewTypeChecker()
+	r.tc.DeclareFuncs(r.km)
+	r.setTrace(r.trace)
+}
+
+func (r *repl) setTrace(on bool) {
+	r.trace = on
+	if on {
+		r.km.SetTracer(minifp.NewTextTracer(r.out))
+	} else {
+		r.km.SetTracer(nil)
+	}
+}
+
+// run reads and evaluates inputs until the end of input or :quit.
+func (r *repl) run() {
+	r.loadHistory()
+	var lines []string
+	for {
+		if len(lines) == 0 {
+			fmt.Fprint(r.out, prompt)
+		} else {
+			fmt.Fprint(r.out, contPrompt)
+		}
+		if !r.in.Scan() {
+			fmt.Fprintln(r.out)
+			return
+		}
+		line := r.in.Text()
+		if len(lines) == 0 {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" {
+				continue
+			}
+			if strings.HasPrefix(trimmed, ":") {
+				r.addHistory(trimmed)
+				if !r.command(trimmed) {
+					return
+				}
+				continue
+			}
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+			if incomplete(strings.Join(lines, "\n")) {
+				continue
+			}
+		}
+		src := strings.Join(lines, "\n")
+		lines = nil
+		r.addHistory(src)
+		r.eval("", src)
+	}
+}
+
+// incomplete checks if src ends before the expression does, e.g., with an
+// unbalanced parenthesis.
+func incomplete(src string) bool {
+	_, err := minifp.ParseErr(strings.NewReader(src))
+	var errs minifp.ErrorList
+	if !errors.As(err, &errs) {
+		return false
+	}
+	for _, e := range errs {
+		if e.Token == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// command runs a ":" command. It returns false if the session should end.
+func (r *repl) command(line string) bool {
+	name, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch name {
+	case ":q", ":quit":
+		return false
+	case ":h", ":help":
+		fmt.Fprint(r.out, replHelp)
+	case ":t", ":type":
+		r.forEach(arg, func(node minifp.ASTNode) error {
+			typ, err := r.tc.Check(node)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(r.out, "%v :: %v\n", node, typ)
+			return nil
+		})
+	case ":ast":
+		r.forEach(arg, func(node minifp.ASTNode) error {
+			fmt.Fprintln(r.out, node)
+			return nil
+		})
+	case ":kcode":
+		r.forEach(arg, func(node minifp.ASTNode) error {
+			code, _, err := r.define(node)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(r.out, dumpCode(code))
+			return nil
+		})
+	case ":trace":
+		switch arg {
+		case "on":
+			r.setTrace(true)
+		case "off":
+			r.setTrace(false)
+		default:
+			fmt.Fprintln(r.out, "usage: :trace on|off")
+		}
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(r.out, "usage: :load FILE")
+			break
+		}
+		data, err := ioutil.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+			break
+		}
+		r.eval(arg, string(data))
+	case ":reset":
+		r.reset()
+	case ":history":
+		for i, h := range r.history {
+			fmt.Fprintf(r.out, "%4d  %s\n", i+1, h)
+		}
+	default:
+		fmt.Fprintf(r.out, "unknown command %s; type :help for help\n", name)
+	}
+	return true
+}
+
+// forEach parses src and calls fn for each toplevel expression. It reports
+// errors to the output.
+func (r *repl) forEach(src string, fn func(node minifp.ASTNode) error) {
+	nodes, err := minifp.ParseErr(strings.NewReader(src))
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	for _, node := range nodes {
+		if err := fn(node); err != nil {
+			fmt.Fprintln(r.out, err)
+			return
+		}
+	}
+}
+
+// eval evaluates the toplevel expressions in src. A definition is added to the
+// globals without being evaluated. Filename is used in error messages.
+func (r *repl) eval(filename, src string) {
+	nodes, err := minifp.ParseFile(filename, strings.NewReader(src))
+	prevLoader := r.loader
+	if err == nil {
+		r.loader = prevLoader.Clone()
+		nodes, err = r.loader.Load(nodes)
+	}
+	if err != nil {
+		fmt.Fprintln(r.out, err)
+		return
+	}
+	for _, node := range nodes {
+		code, typ, err := r.define(node)
+		if err != nil {
+			// Forget the modules loaded by src, so that they are compiled again
+			// when they are imported next time.
+			r.loader = prevLoader
+			fmt.Fprintln(r.out, err)
+			return
+		}
+		switch v := node.(type) {
+		case *minifp.ASTAssign:
+			fmt.Fprintf(r.out, "%v :: %v\n", v.Sym, typ)
+			continue
+		case *minifp.ASTData:
+			continue
+		}
+		val, err := r.runCode(code)
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+			return
+		}
+		if val.Type() == minifp.LiteralFunc {
+			fmt.Fprintf(r.out, "<function> :: %v\n", typ)
+			continue
+		}
+		fmt.Fprintln(r.out, val)
+	}
+}
+
+// define type-checks and compiles the node. If the node is a definition, it is
+// added to the machine and the type checker.
+func (r *repl) define(node minifp.ASTNode) (minifp.KCode, minifp.Type, error) {
+	typ, err := r.tc.Check(node)
+	if err != nil {
+		return nil, nil, err
+	}
+	code, err := r.km.CompileErr(node)
+	if err != nil {
+		return nil, nil, err
+	}
+	return code, typ, nil
+}
+
+// runCode evaluates the code. The evaluation is aborted on interrupt.
+func (r *repl) runCode(code minifp.KCode) (minifp.Literal, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		cancel()
+	}()
+	go func() {
+		select {
+		case <-r.interrupt:
+			cancel()
+		case <-done:
+		}
+	}()
+	return r.km.RunContext(ctx, code)
+}
+
+// dumpCode renders the code. Unlike KCode.DebugString, it shows the bodies of
+// lambdas.
+func dumpCode(code minifp.KCode) string {
+	switch v := code.(type) {
+	case *minifp.KLambda:
+		return fmt.Sprintf("ƛ%v.%s", v.Arg, dumpCode(v.Body))
+	case *minifp.KApply:
+		return fmt.Sprintf("(%s %s)", dumpCode(v.Head), dumpCode(v.Tail))
+	case *minifp.KLetrec:
+		var buf strings.Builder
+		buf.WriteString("letrec")
+		for i, sym := range v.VarNames {
+			fmt.Fprintf(&buf, " %v=%s", sym, dumpCode(v.VarExprs[i]))
+		}
+		buf.WriteString(" in ")
+		buf.WriteString(dumpCode(v.Body))
+		return buf.String()
+	}
+	return code.DebugString()
+}
+
+// loadHistory reads the history file. Each line is a quoted entry, since an
+// entry may span multiple lines.
+func (r *repl) loadHistory() {
+	if r.historyFile == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(r.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if entry, err := strconv.Unquote(line); err == nil {
+			r.history = append(r.history, entry)
+		}
+	}
+	r.historyLines = len(r.history)
+	if n := len(r.history); n > maxHistory {
+		r.history = r.history[n-maxHistory:]
+	}
+}
+
+// addHistory records an entry and appends it to the history file. Once the file
+// holds 2*maxHistory entries, it is rewritten with the last maxHistory ones.
+func (r *repl) addHistory(entry string) {
+	r.history = append(r.history, entry)
+	if n := len(r.history); n > maxHistory {
+		r.history = r.history[n-maxHistory:]
+	}
+	if r.historyFile == "" {
+		return
+	}
+	entries, flag := []string{entry}, os.O_APPEND
+	if r.historyLines++; r.historyLines > 2*maxHistory {
+		entries, flag = r.history, os.O_TRUNC
+		r.historyLines = len(r.history)
+	}
+	f, err := os.OpenFile(r.historyFile, os.O_WRONLY|os.O_CREATE|flag, 0600)
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range entries {
+		fmt.Fprintln(w, strconv.Quote(e))
+	}
+	w.Flush()
+	f.Close()
+}
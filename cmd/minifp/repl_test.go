@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+)
+
+// runREPL runs a session with the given input, and returns the output.
+func runREPL(t *testing.T, historyFile, input string) string {
+	var out strings.Builder
+	r := newREPL(strings.NewReader(input), &out, nil)
+	r.historyFile = historyFile
+	r.run()
+	return out.String()
+}
+
+func TestREPL(t *testing.T) {
+	out := runREPL(t, "", `
+double x = x * 2
+double 21
+fact n = if (n == 0)
+  1
+  (n * fact (n - 1))
+
+fact 5
+map double [1, 2]
+map double
+:type double
+:ast 1 + 2
+:kcode \x -> x
+`)
+	for _, want := range []string{
+		"double :: num -> num\n",
+		"42\n",
+		"fact :: num -> num\n",
+		"120\n",
+		"[2, 4]\n",
+		"<function> :: [Int] -> [Int]\n",
+		// The numeric type of an expression defaults to Int.
+		"double :: Int -> Int\n",
+		"(1 + 2)\n",
+		"ƛx.localvar:{0 0}\n",
+	} {
+		expect.HasSubstr(t, out, want)
+	}
+	// The continuation prompt is shown for the lines after the first one.
+	expect.HasSubstr(t, out, prompt+contPrompt+contPrompt+"fact :: num -> num")
+
+	// An infinite list is printed up to maxForce elements.
+	out = runREPL(t, "", "iterate (\\x -> x + 1) 0\n")
+	expect.HasSubstr(t, out, prompt+"0 : 1 : 2 : ")
+	expect.HasSubstr(t, out, " : 998 : 999 : ...\n")
+}
+
+func TestREPLErrors(t *testing.T) {
+	out := runREPL(t, "", `
+noSuchVar
+1 +
+
+"a" + 1
+head xs = case xs of {x : _ -> x}
+head []
+:bogus
+:trace maybe
+1 + 1
+`)
+	for _, want := range []string{
+		"<input>:1:1: variable noSuchVar not found\n",
+		"syntax error: unexpected EOF",
+		"type mismatch: expect Int, but found String\n",
+		"head :: [a] -> a\n",
+		"non-exhaustive patterns in case",
+		"unknown command :bogus",
+		"usage: :trace on|off\n",
+		"2\n",
+	} {
+		expect.HasSubstr(t, out, want)
+	}
+}
+
+func TestREPLCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repl")
+	expect.NoError(t, err)
+	defer os.RemoveAll(dir)
+	lib := filepath.Join(dir, "lib.mfp")
+	expect.NoError(t, ioutil.WriteFile(lib, []byte("inc x = x + 1;\ntwice f x = f (f x);\ntwice inc 0\n"), 0600))
+
+	out := runREPL(t, "", `
+:load `+lib+`
+twice inc 10
+:trace on
+inc 1
+:trace off
+:reset
+inc 1
+:load `+filepath.Join(dir, "missing.mfp")+`
+:quit
+1
+`)
+	for _, want := range []string{
+		"inc :: num -> num\n",
+		"twice :: (a -> a) -> a -> a\n",
+		"2\n",
+		"12\n",
+		"1: (localvar:",
+		"<input>:1:1: variable inc not found\n",
+		"missing.mfp: no such file or directory\n",
+	} {
+		expect.HasSubstr(t, out, want)
+	}
+	// The input after :quit is ignored.
+	expect.True(t, strings.HasSuffix(out, "missing.mfp: no such file or directory\n"+prompt))
+
+	out = runREPL(t, "", ":load "+lib+"x\n")
+	expect.HasSubstr(t, out, "no such file")
+	expect.NoError(t, ioutil.WriteFile(lib, []byte("f x = x +\n"), 0600))
+	out = runREPL(t, "", ":load "+lib+"\n")
+	expect.HasSubstr(t, out, lib+":2:1: syntax error")
+}
+
+func TestREPLHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repl")
+	expect.NoError(t, err)
+	defer os.RemoveAll(dir)
+	history := filepath.Join(dir, "history")
+
+	runREPL(t, history, "f x = (x +\n  1)\n:type f\n")
+	out := runREPL(t, history, "1 + 2\n:history\n")
+	expect.HasSubstr(t, out, "   1  f x = (x +\n  1)\n   2  :type f\n   3  1 + 2\n   4  :history\n")
+
+	readLines := func() []string {
+		data, err := ioutil.ReadFile(history)
+		expect.NoError(t, err)
+		return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	// Entries are appended until the file holds 2*maxHistory of them.
+	var in strings.Builder
+	for i := 4; i < 2*maxHistory; i++ {
+		fmt.Fprintf(&in, "%d\n", i)
+	}
+	runREPL(t, history, in.String())
+	lines := readLines()
+	expect.EQ(t, len(lines), 2*maxHistory)
+	expect.EQ(t, lines[0], `"f x = (x +\n  1)"`)
+
+	// Then the file is rewritten with the last maxHistory entries.
+	runREPL(t, history, "42\n")
+	lines = readLines()
+	expect.EQ(t, len(lines), maxHistory)
+	expect.EQ(t, lines[0], fmt.Sprintf(`"%d"`, maxHistory+1))
+	expect.EQ(t, lines[maxHistory-1], `"42"`)
+	runREPL(t, history, "43\n")
+	expect.EQ(t, len(readLines()), maxHistory+1)
+}
+
+func TestREPLModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repl")
+	expect.NoError(t, err)
+	defer os.RemoveAll(dir)
+	expect.NoError(t, ioutil.WriteFile(filepath.Join(dir, "A.mfp"), []byte("module A; x = 42"), 0600))
+	expect.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Bad.mfp"), []byte("module Bad; y = \"a\" + 1"), 0600))
+
+	var out strings.Builder
+	r := newREPL(strings.NewReader(`
+import A; import Missing
+import A
+A.x
+import Bad
+import Bad
+`), &out, []string{dir})
+	r.run()
+	expect.HasSubstr(t, out.String(), "module Missing not found")
+	expect.HasSubstr(t, out.String(), "A.x :: num\n")
+	expect.HasSubstr(t, out.String(), "42\n")
+	// A module that fails to compile is compiled again by the next import.
+	expect.EQ(t, strings.Count(out.String(), "type mismatch: expect Int, but found String"), 2)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+// Exit codes of "minifp run".
+const (
+	exitOK = 0
+	// exitIOError is reported when the script cannot be read.
+	exitIOError = 1
+	// exitUsage is reported for invalid command-line arguments.
+	exitUsage = 2
+	// exitSyntaxError is reported when the script cannot be parsed.
+	exitSyntaxError = 3
+	// exitCompileError is reported when the script fails to type-check or
+	// compile, e.g., if it doesn't define main.
+	exitCompileError = 4
+	// exitRuntimeError is reported when the evaluation of main fails.
+	exitRuntimeError = 5
+)
+
+// mainSym is the name of the binding that a script evaluates.
+var mainSym = minifp.InternSymbol("main")
+
+// runScript runs "minifp run path args...". The script consists of
+// definitions and imports. If main is a function, it is applied to args as a
+// list of strings. The value of main is printed to stdout; a string is printed
+// without quotes. Imported modules are searched for in the directory of the
+// script, then in modulePath. It returns the exit code.
+func runScript(stdout, stderr io.Writer, path string, args []string, modulePath []string) int {
+	src, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitIOError
+	}
+	defer src.Close()
+	nodes, err := minifp.ParseFile(path, src)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitSyntaxError
+	}
+	loader := minifp.NewModuleLoader(append([]string{filepath.Dir(path)}, modulePath...)...)
+	if nodes, err = loader.Load(nodes); err != nil {
+		fmt.Fprintln(stderr, err)
+		var errs minifp.ErrorList
+		if errors.As(err, &errs) {
+			return exitSyntaxError
+		}
+		return exitCompileError
+	}
+
+	km := minifp.NewMachine(minifp.MaxForce(maxForce))
+	tc := minifp.NewTypeChecker()
+	tc.DeclareFuncs(km)
+	// The definitions form one recursive group, so they may appear in any order.
+	types, err := tc.CheckDefs(nodes)
+	if err == nil {
+		err = km.CompileDefs(nodes)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitCompileError
+	}
+	var mainType minifp.Type
+	for i, node := range nodes {
+		if v, ok := node.(*minifp.ASTAssign); ok && v.Sym == mainSym {
+			mainType = types[i]
+		}
+	}
+	if mainType == nil {
+		fmt.Fprintf(stderr, "%s: main is not defined\n", path)
+		return exitCompileError
+	}
+
+	// Evaluate "main" or "main [args...]".
+	expr := "main"
+	if t, ok := mainType.(*minifp.TypeCon); ok && t.Name == "->" {
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = strconv.Quote(arg)
+		}
+		expr = "main [" + strings.Join(quoted, ", ") + "]"
+	} else if len(args) > 0 {
+		fmt.Fprintf(stderr, "%s: main takes no arguments\n", path)
+		return exitUsage
+	}
+	node := minifp.Parse(strings.NewReader(expr))[0]
+	if _, err := tc.Check(node); err != nil {
+		fmt.Fprintf(stderr, "%s: main must be a value or a function of [String]: %v\n", path, err)
+		return exitCompileError
+	}
+	val, err := km.RunErr(km.Compile(node))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitRuntimeError
+	}
+	if val.Type() == minifp.LiteralString {
+		fmt.Fprintln(stdout, val.Str())
+	} else {
+		fmt.Fprintln(stdout, val)
+	}
+	return exitOK
+}
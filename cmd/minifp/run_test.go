@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+)
+
+func TestRunScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run")
+	expect.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		src    string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{src: "main = 6 * 7", code: exitOK, stdout: "42\n"},
+		{src: `main = "a" ++ "b"`, code: exitOK, stdout: "ab\n"},
+		{src: "greet s = \"hi \" ++ s;\nmain args = map greet args", args: []string{"x", `"y"`},
+			code: exitOK, stdout: `["hi x", "hi \"y\""]` + "\n"},
+		{src: "main args = length args", code: exitOK, stdout: "0\n"},
+		{src: "data Color = Red | Green;\nmain = Green", code: exitOK, stdout: "Green\n"},
+		// The definitions may refer to those that follow them.
+		{src: "main = f 1;\nf x = x + 1", code: exitOK, stdout: "2\n"},
+		{src: "main = Blue;\ndata Color = Blue", code: exitOK, stdout: "Blue\n"},
+		{src: "main = [isEven 10, isOdd 10];\nisEven n = if (n == 0) (0 == 0) (isOdd (n - 1));\nisOdd n = if (n == 0) (0 == 1) (isEven (n - 1))",
+			code: exitOK, stdout: "[true, false]\n"},
+		{src: "main = ident \"a\" ++ show (ident 1);\nident x = x", code: exitOK, stdout: "a1\n"},
+		{src: "f = 1;\nmain = f;\nf = 2", code: exitCompileError, stderr: "script.mfp:3:1: f is defined more than once"},
+		{src: "main = 1", args: []string{"x"}, code: exitUsage, stderr: "main takes no arguments"},
+		{src: "main = (1 +", code: exitSyntaxError, stderr: "script.mfp:1:12: syntax error"},
+		{src: "f x = x;\n\nmain = f \"a\" + 1", code: exitCompileError,
+			stderr: "script.mfp:3:8: type mismatch: expect Int, but found String"},
+		{src: "f x = x;\nf 1", code: exitCompileError, stderr: "script.mfp:2:1: toplevel expression (f 1) is not a definition"},
+		{src: "f x = x", code: exitCompileError, stderr: "script.mfp: main is not defined"},
+		{src: "main x = x + 1", code: exitCompileError, stderr: "main must be a value or a function of [String]"},
+		{src: "main = case [] of {x : _ -> x + 1}", code: exitRuntimeError,
+			stderr: "script.mfp:1:8: step"},
+	} {
+		path := filepath.Join(dir, "script.mfp")
+		expect.NoError(t, ioutil.WriteFile(path, []byte(test.src), 0600))
+		var stdout, stderr strings.Builder
+		code := runScript(&stdout, &stderr, path, test.args, nil)
+		expect.EQ(t, code, test.code, test.src)
+		expect.EQ(t, stdout.String(), test.stdout, test.src)
+		if test.stderr == "" {
+			expect.EQ(t, stderr.String(), "", test.src)
+		} else {
+			expect.HasSubstr(t, stderr.String(), test.stderr, test.src)
+		}
+	}
+
+	// An infinite list is printed up to maxForce elements.
+	path := filepath.Join(dir, "script.mfp")
+	expect.NoError(t, ioutil.WriteFile(path, []byte(`main = iterate (\x -> x + 1) 0`), 0600))
+	var stdout, stderr strings.Builder
+	code := runScript(&stdout, &stderr, path, nil, nil)
+	expect.EQ(t, code, exitOK)
+	expect.HasPrefix(t, stdout.String(), "0 : 1 : 2 : ")
+	expect.True(t, strings.HasSuffix(stdout.String(), " : 998 : 999 : ...\n"), stdout.String())
+
+	stdout.Reset()
+	stderr.Reset()
+	code = runScript(&stdout, &stderr, filepath.Join(dir, "missing.mfp"), nil, nil)
+	expect.EQ(t, code, exitIOError)
+	expect.HasSubstr(t, stderr.String(), "no such file or directory")
+}
+
+func TestRunScriptModules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "run")
+	expect.NoError(t, err)
+	defer os.RemoveAll(dir)
+	lib := filepath.Join(dir, "lib")
+	expect.NoError(t, os.Mkdir(lib, 0700))
+	expect.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Greet.mfp"),
+		[]byte("module Greet;\nimport Text (exclaim);\ngreet s = exclaim (\"hi \" ++ s)"), 0600))
+	expect.NoError(t, ioutil.WriteFile(filepath.Join(lib, "Text.mfp"),
+		[]byte("module Text;\nexclaim s = s ++ \"!\""), 0600))
+	path := filepath.Join(dir, "main.mfp")
+	expect.NoError(t, ioutil.WriteFile(path, []byte("import Greet;\nmain args = map greet args"), 0600))
+
+	var stdout, stderr strings.Builder
+	code := runScript(&stdout, &stderr, path, []string{"bob"}, []string{lib})
+	expect.EQ(t, code, exitOK, stderr.String())
+	expect.EQ(t, stdout.String(), `["hi bob!"]`+"\n")
+
+	stdout.Reset()
+	code = runScript(&stdout, &stderr, path, nil, nil)
+	expect.EQ(t, code, exitCompileError)
+	expect.HasSubstr(t, stderr.String(), "Greet.mfp:2:1: module Text not found")
+}
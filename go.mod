@@ -1,5 +1,7 @@
 module github.com/yasushi-saito/minifp
 
-go 1.13
+go 1.18
 
 require github.com/grailbio/testutil v0.0.3
+
+require github.com/davecgh/go-spew v1.1.1 // indirect
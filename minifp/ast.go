@@ -9,6 +9,8 @@ import (
 type ASTNode interface {
 	// scanner.Position returns the source-code location of this node.
 	Pos() scanner.Position
+	// String returns the node in the source syntax. Compound expressions are
+	// parenthesized, so that Parse produces the same tree from the result.
 	String() string
 }
 
@@ -18,7 +20,12 @@ type ASTConst struct {
 }
 
 func (n ASTConst) Pos() scanner.Position { return n.pos }
-func (n ASTConst) String() string        { return n.Val.String() }
+func (n ASTConst) String() string {
+	if isNumber(n.Val) && strings.HasPrefix(n.Val.String(), "-") {
+		return "(" + n.Val.String() + ")"
+	}
+	return n.Val.String()
+}
 
 type ASTVar struct {
 	pos scanner.Position
@@ -44,7 +51,9 @@ type ASTLambda struct {
 }
 
 func (n ASTLambda) Pos() scanner.Position { return n.pos }
-func (n ASTLambda) String() string        { return "ƛ" + n.Arg.String() + "." + n.Body.String() }
+func (n ASTLambda) String() string {
+	return `(\` + n.Arg.String() + " -> " + n.Body.String() + ")"
+}
 
 type ASTAssign struct {
 	pos  scanner.Position
@@ -54,7 +63,7 @@ type ASTAssign struct {
 
 func (n ASTAssign) Pos() scanner.Position { return n.pos }
 func (n ASTAssign) String() string {
-	return n.Sym.String() + "=" + n.Expr.String()
+	return n.Sym.String() + " = " + n.Expr.String()
 }
 
 type ASTApplyLeafFunction struct {
@@ -66,6 +75,12 @@ type ASTApplyLeafFunction struct {
 func (n ASTApplyLeafFunction) Pos() scanner.Position { return n.pos }
 
 func (n ASTApplyLeafFunction) String() string {
+	if op := strings.TrimPrefix(n.Op.name, "builtin:"); op != n.Op.name && len(n.Args) == 2 {
+		return "(" + n.Args[0].String() + " " + op + " " + n.Args[1].String() + ")"
+	}
+	if n.Op == funcs["negate"] && len(n.Args) == 1 {
+		return "(-" + n.Args[0].String() + ")"
+	}
 	var buf strings.Builder
 	buf.WriteRune('(')
 	buf.WriteString(fmt.Sprint(n.Op))
@@ -85,7 +100,18 @@ type ASTLetrec struct {
 
 func (n ASTLetrec) Pos() scanner.Position { return n.pos }
 func (n ASTLetrec) String() string {
-	return fmt.Sprintf("letrec %+v in %v", n.Bindings, n.Body)
+	var buf strings.Builder
+	buf.WriteString("(letrec ")
+	for i, b := range n.Bindings {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		buf.WriteString(b.String())
+	}
+	buf.WriteString(" in ")
+	buf.WriteString(n.Body.String())
+	buf.WriteRune(')')
+	return buf.String()
 }
 
 type ASTIf struct {
@@ -95,5 +121,194 @@ type ASTIf struct {
 
 func (n ASTIf) Pos() scanner.Position { return n.pos }
 func (n ASTIf) String() string {
-	return fmt.Sprintf("if %v %v %v", n.Cond, n.Then, n.Else)
+	return fmt.Sprintf("(if %v %v %v)", n.Cond, n.Then, n.Else)
+}
+
+// ASTCon is a reference to a data constructor, e.g., "Just".
+type ASTCon struct {
+	pos scanner.Position
+	Sym Symbol
+}
+
+func (n ASTCon) Pos() scanner.Position { return n.pos }
+func (n ASTCon) String() string {
+	if n.Sym == consSpec.sym {
+		return "(:)"
+	}
+	return n.Sym.String()
+}
+
+// ASTConDecl is one constructor in a data declaration, e.g., "Just a".
+type ASTConDecl struct {
+	pos    scanner.Position
+	Sym    Symbol
+	Fields []Type
+}
+
+func (n ASTConDecl) String() string {
+	var buf strings.Builder
+	buf.WriteString(n.Sym.String())
+	for _, f := range n.Fields {
+		buf.WriteRune(' ')
+		writeType(&buf, f, &typeNamer{}, typePrecArg)
+	}
+	return buf.String()
+}
+
+// ASTData is a toplevel data declaration, e.g., "data Maybe a = Nothing | Just a".
+type ASTData struct {
+	pos    scanner.Position
+	Sym    Symbol
+	Params []Symbol
+	Cons   []*ASTConDecl
+}
+
+func (n ASTData) Pos() scanner.Position { return n.pos }
+func (n ASTData) String() string {
+	var buf strings.Builder
+	buf.WriteString("data ")
+	buf.WriteString(n.Sym.String())
+	for _, p := range n.Params {
+		buf.WriteRune(' ')
+		buf.WriteString(p.String())
+	}
+	for i, c := range n.Cons {
+		if i == 0 {
+			buf.WriteString(" = ")
+		} else {
+			buf.WriteString(" | ")
+		}
+		buf.WriteString(c.String())
+	}
+	return buf.String()
+}
+
+// ASTModule is a toplevel module declaration, e.g., "module Foo". It names the
+// module defined by the file.
+type ASTModule struct {
+	pos  scanner.Position
+	Name string
+}
+
+func (n ASTModule) Pos() scanner.Position { return n.pos }
+func (n ASTModule) String() string        { return "module " + n.Name }
+
+// ASTImport is a toplevel import declaration, e.g., "import Foo (x, y)". Names
+// is nil if the declaration has no import list, in which case all the
+// definitions of the module are imported.
+type ASTImport struct {
+	pos    scanner.Position
+	Module string
+	Names  []Symbol
+}
+
+func (n ASTImport) Pos() scanner.Position { return n.pos }
+func (n ASTImport) String() string {
+	var buf strings.Builder
+	buf.WriteString("import ")
+	buf.WriteString(n.Module)
+	if n.Names != nil {
+		buf.WriteString(" (")
+		for i, sym := range n.Names {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(sym.String())
+		}
+		buf.WriteRune(')')
+	}
+	return buf.String()
+}
+
+// ASTPattern is the left-hand side of a case alternative.
+type ASTPattern interface {
+	Pos() scanner.Position
+	String() string
+}
+
+// ASTPatVar matches any value and binds it to the variable.
+type ASTPatVar struct {
+	pos scanner.Position
+	Sym Symbol
+}
+
+func (n ASTPatVar) Pos() scanner.Position { return n.pos }
+func (n ASTPatVar) String() string        { return n.Sym.String() }
+
+// ASTPatWildcard, "_", matches any value.
+type ASTPatWildcard struct {
+	pos scanner.Position
+}
+
+func (n ASTPatWildcard) Pos() scanner.Position { return n.pos }
+func (n ASTPatWildcard) String() string        { return "_" }
+
+// ASTPatLiteral matches a value equal to the literal.
+type ASTPatLiteral struct {
+	pos scanner.Position
+	Val Literal
+}
+
+func (n ASTPatLiteral) Pos() scanner.Position { return n.pos }
+func (n ASTPatLiteral) String() string        { return n.Val.String() }
+
+// ASTPatCon matches a constructor application, e.g., "Just x".
+type ASTPatCon struct {
+	pos  scanner.Position
+	Sym  Symbol
+	Args []ASTPattern
+}
+
+func (n ASTPatCon) Pos() scanner.Position { return n.pos }
+func (n ASTPatCon) String() string {
+	if len(n.Args) == 0 {
+		return n.Sym.String()
+	}
+	var buf strings.Builder
+	buf.WriteRune('(')
+	if n.Sym == consSpec.sym {
+		buf.WriteString(n.Args[0].String())
+		buf.WriteString(" : ")
+		buf.WriteString(n.Args[1].String())
+		buf.WriteRune(')')
+		return buf.String()
+	}
+	buf.WriteString(n.Sym.String())
+	for _, arg := range n.Args {
+		buf.WriteRune(' ')
+		buf.WriteString(arg.String())
+	}
+	buf.WriteRune(')')
+	return buf.String()
+}
+
+type ASTCaseAlt struct {
+	Pat  ASTPattern
+	Body ASTNode
+}
+
+// ASTCase is "case Expr of {Pat -> Body; ...}". The alternatives are tried in
+// order.
+type ASTCase struct {
+	pos  scanner.Position
+	Expr ASTNode
+	Alts []ASTCaseAlt
+}
+
+func (n ASTCase) Pos() scanner.Position { return n.pos }
+func (n ASTCase) String() string {
+	var buf strings.Builder
+	buf.WriteString("case ")
+	buf.WriteString(n.Expr.String())
+	buf.WriteString(" of {")
+	for i, alt := range n.Alts {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		buf.WriteString(alt.Pat.String())
+		buf.WriteString(" -> ")
+		buf.WriteString(alt.Body.String())
+	}
+	buf.WriteRune('}')
+	return buf.String()
 }
@@ -0,0 +1,174 @@
+package minifp
+
+import (
+	"errors"
+	"math"
+	"math/big"
+)
+
+// OverflowMode specifies what happens when an integer operation overflows
+// int64.
+type OverflowMode int
+
+const (
+	// OverflowBigInt promotes the result to an arbitrary-precision integer. This
+	// is the default.
+	OverflowBigInt OverflowMode = iota
+	// OverflowWrap truncates the result to 64 bits, as Go does.
+	OverflowWrap
+	// OverflowTrap aborts the evaluation with ErrIntOverflow.
+	OverflowTrap
+)
+
+// IntOverflow sets the behavior of the builtin integer operations on overflow.
+func IntOverflow(mode OverflowMode) Option { return func(k *KMachine) { k.overflow = mode } }
+
+// ErrDivisionByZero is reported when an integer is divided by zero.
+var ErrDivisionByZero = errors.New("division by zero")
+
+// ErrIntOverflow is reported when an integer operation overflows under
+// OverflowTrap.
+var ErrIntOverflow = errors.New("integer overflow")
+
+var bigMask64 = new(big.Int).SetUint64(math.MaxUint64)
+
+// newLiteralBig creates an integer literal. The value is stored as a
+// LiteralInt if it fits in int64, so a LiteralBigInt is always out of the range
+// of int64.
+func newLiteralBig(v *big.Int) Literal {
+	if v.IsInt64() {
+		return NewLiteralInt(v.Int64())
+	}
+	return Literal{typ: LiteralBigInt, bigVal: v}
+}
+
+// Big returns the value of an int or a bigint. The result must not be modified.
+func (l Literal) Big() *big.Int {
+	switch l.typ {
+	case LiteralBigInt:
+		return l.bigVal
+	case LiteralInt:
+		return big.NewInt(l.intVal)
+	}
+	panic("expect an int, but found " + l.String())
+}
+
+// isInt checks if the value is an int or a bigint.
+func isInt(l Literal) bool { return l.typ == LiteralInt || l.typ == LiteralBigInt }
+
+// intArith applies an integer operator. IntOp returns false if the result
+// overflows int64, in which case bigOp computes the exact result.
+func intArith(a, b Literal, intOp func(x, y int64) (int64, bool), bigOp func(z, x, y *big.Int) *big.Int) Literal {
+	if a.typ == LiteralInt && b.typ == LiteralInt {
+		if r, ok := intOp(a.intVal, b.intVal); ok {
+			return NewLiteralInt(r)
+		}
+	}
+	x, y := a.Big(), b.Big()
+	return newLiteralBig(bigOp(new(big.Int), x, y))
+}
+
+func addInt64(x, y int64) (int64, bool) {
+	r := x + y
+	return r, (x >= 0) != (y >= 0) || (r >= 0) == (x >= 0)
+}
+
+func subInt64(x, y int64) (int64, bool) {
+	r := x - y
+	return r, (x >= 0) == (y >= 0) || (r >= 0) == (x >= 0)
+}
+
+func mulInt64(x, y int64) (int64, bool) {
+	if x == 0 || y == 0 {
+		return 0, true
+	}
+	r := x * y
+	return r, r/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64)
+}
+
+// quoInt64 computes the quotient of x / y truncated toward zero.
+func quoInt64(x, y int64) (int64, bool) {
+	if x == math.MinInt64 && y == -1 {
+		return 0, false
+	}
+	return x / y, true
+}
+
+// chargeBigInts charges one step for each 64-bit word of the big-integer
+// arguments of a builtin, since the builtin takes time that grows with their
+// size. It is called before the builtin runs, so that MaxSteps bounds the size
+// of the integers that a program can build.
+func (k *KMachine) chargeBigInts(args ...Literal) {
+	charged := false
+	for _, arg := range args {
+		if arg.typ == LiteralBigInt {
+			k.step += len(arg.bigVal.Bits())
+			charged = true
+		}
+	}
+	if charged && k.maxSteps > 0 && k.step >= k.maxSteps {
+		k.failErr(ErrStepLimit)
+	}
+}
+
+// remInt64 computes the remainder of the truncated division, whose sign is the
+// sign of x.
+func remInt64(x, y int64) (int64, bool) {
+	if y == -1 {
+		return 0, true
+	}
+	return x % y, true
+}
+
+// divInt64 computes the quotient of x / y rounded toward negative infinity.
+func divInt64(x, y int64) (int64, bool) {
+	q, ok := quoInt64(x, y)
+	if ok && x%y != 0 && (x < 0) != (y < 0) {
+		q--
+	}
+	return q, ok
+}
+
+// modInt64 computes the remainder of divInt64, whose sign is the sign of y.
+func modInt64(x, y int64) (int64, bool) {
+	r, _ := remInt64(x, y)
+	if r != 0 && (r < 0) != (y < 0) {
+		r += y
+	}
+	return r, true
+}
+
+// bigDiv is divInt64 for big ints. Note that big.Int.Div implements Euclidean
+// division, which differs when y is negative.
+func bigDiv(z, x, y *big.Int) *big.Int {
+	m := new(big.Int)
+	z.QuoRem(x, y, m)
+	if m.Sign() != 0 && m.Sign() != y.Sign() {
+		z.Sub(z, big.NewInt(1))
+	}
+	return z
+}
+
+// bigMod is modInt64 for big ints.
+func bigMod(z, x, y *big.Int) *big.Int {
+	z.Rem(x, y)
+	if z.Sign() != 0 && z.Sign() != y.Sign() {
+		z.Add(z, y)
+	}
+	return z
+}
+
+// fitInt applies the machine's overflow mode to the result of a builtin.
+func (k *KMachine) fitInt(val Literal) Literal {
+	if val.typ != LiteralBigInt {
+		return val
+	}
+	switch k.overflow {
+	case OverflowWrap:
+		low := new(big.Int).And(val.bigVal, bigMask64)
+		return NewLiteralInt(int64(low.Uint64()))
+	case OverflowTrap:
+		k.failErr(ErrIntOverflow)
+	}
+	return val
+}
@@ -0,0 +1,76 @@
+package minifp_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+const factSrc = `fact n = if (n == 0) 1 (n * fact (n - 1))`
+
+func TestBigInt(t *testing.T) {
+	km := minifp.NewMachine()
+	run(t, km, factSrc)
+	for _, test := range []struct{ src, want string }{
+		{`fact 20`, "2432902008176640000"},
+		{`fact 30`, "265252859812191058636308480000000"},
+		{`fact 30 / fact 28`, "870"},
+		{`9223372036854775807 + 1`, "9223372036854775808"},
+		{`0 - 9223372036854775807 - 2`, "-9223372036854775809"},
+		{`(0 - 9223372036854775807 - 1) / (0 - 1)`, "9223372036854775808"},
+		{`4294967296 * 4294967296`, "18446744073709551616"},
+		{`99999999999999999999`, "99999999999999999999"},
+		{`0x10000000000000000`, "18446744073709551616"},
+		// A result that fits in 64 bits is an ordinary int again.
+		{`99999999999999999999 - 99999999999999999998`, "1"},
+		{`case 99999999999999999999 - 99999999999999999998 of {1 -> 10; _ -> 20}`, "10"},
+		{`99999999999999999999 > 1`, "true"},
+		{`0 - 99999999999999999999 < 1`, "true"},
+		{`99999999999999999999 == 99999999999999999999`, "true"},
+		{`99999999999999999999 + 0.5`, "1e+20"},
+		{`floor 1e20`, "100000000000000000000"},
+		{`show (fact 25)`, `"15511210043330985984000000"`},
+	} {
+		expect.EQ(t, run(t, km, test.src).String(), test.want, test.src)
+	}
+
+	x := minifp.Parse(strings.NewReader(`strTake 99999999999999999999 "abc"`))
+	_, err := km.RunErr(km.Compile(x[0]))
+	expect.HasSubstr(t, err.Error(), "integer 99999999999999999999 is out of range")
+
+	got, err := typeOf(t, factSrc+`; fact 30 + 99999999999999999999`)
+	expect.NoError(t, err)
+	expect.EQ(t, got, "Int")
+}
+
+func TestBigIntStepLimit(t *testing.T) {
+	// Squaring doubles the size of x, so the program would run out of memory
+	// after a few dozen steps if MaxSteps didn't account for the size.
+	km := minifp.NewMachine(minifp.MaxSteps(2000))
+	x := minifp.Parse(strings.NewReader(`letrec f x = if (x == 0) 0 (f (x * x)) in f 3`))
+	_, err := km.RunErr(km.Compile(x[0]))
+	expect.True(t, errors.Is(err, minifp.ErrStepLimit), err)
+
+	// Small big integers cost a few steps.
+	run(t, km, factSrc)
+	expect.EQ(t, run(t, km, `fact 30`).String(), "265252859812191058636308480000000")
+}
+
+func TestIntOverflowMode(t *testing.T) {
+	km := minifp.NewMachine(minifp.IntOverflow(minifp.OverflowWrap))
+	run(t, km, factSrc)
+	expect.EQ(t, run(t, km, `fact 30`).String(), "-8764578968847253504")
+	expect.EQ(t, run(t, km, `9223372036854775807 + 1`).String(), "-9223372036854775808")
+	expect.EQ(t, run(t, km, `fact 20`).String(), "2432902008176640000")
+
+	km = minifp.NewMachine(minifp.IntOverflow(minifp.OverflowTrap))
+	run(t, km, factSrc)
+	expect.EQ(t, run(t, km, `fact 20`).String(), "2432902008176640000")
+	x := minifp.Parse(strings.NewReader(`fact 21`))
+	_, err := km.RunErr(km.Compile(x[0]))
+	expect.True(t, errors.Is(err, minifp.ErrIntOverflow), err)
+	expect.EQ(t, err.(*minifp.RuntimeError).Code.DebugString(), "builtin:*")
+}
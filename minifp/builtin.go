@@ -1,11 +1,24 @@
 package minifp
 
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
 type funcSpec struct {
 	name string
 	nArg int
-	cb   func(args ...Literal) Literal
+	// sig is the type signature, e.g., "Int -> Int -> Bool".
+	sig     string
+	sigType Type
+	cb      func(args ...Literal) Literal
 }
 
+func (f *funcSpec) String() string { return f.name }
+
 var funcs map[string]*funcSpec
 
 func init() {
@@ -13,30 +26,80 @@ func init() {
 		"builtin:+": &funcSpec{
 			name: "builtin:+",
 			nArg: 2,
+			sig:  "num -> num -> num",
 			cb: func(args ...Literal) Literal {
-				return NewLiteralInt(args[0].Int() + args[1].Int())
+				return arith(args[0], args[1], addInt64, (*big.Int).Add,
+					func(x, y float64) float64 { return x + y })
 			},
 		},
 		"builtin:-": &funcSpec{
 			name: "builtin:-",
 			nArg: 2,
+			sig:  "num -> num -> num",
 			cb: func(args ...Literal) Literal {
-				return NewLiteralInt(args[0].Int() - args[1].Int())
+				return arith(args[0], args[1], subInt64, (*big.Int).Sub,
+					func(x, y float64) float64 { return x - y })
 			},
 		},
 		"builtin:*": &funcSpec{
 			name: "builtin:*",
 			nArg: 2,
+			sig:  "num -> num -> num",
+			cb: func(args ...Literal) Literal {
+				return arith(args[0], args[1], mulInt64, (*big.Int).Mul,
+					func(x, y float64) float64 { return x * y })
+			},
+		},
+		// "/" truncates toward zero on ints, like Go, so -7 / 2 is -3.
+		"builtin:/": &funcSpec{
+			name: "builtin:/",
+			nArg: 2,
+			sig:  "num -> num -> num",
+			cb: func(args ...Literal) Literal {
+				checkDivisor(args[0], args[1])
+				return arith(args[0], args[1], quoInt64, (*big.Int).Quo,
+					func(x, y float64) float64 { return x / y })
+			},
+		},
+		// "%" is the remainder of "/", and its sign is the sign of the dividend,
+		// so -7 % 2 is -1.
+		"builtin:%": &funcSpec{
+			name: "builtin:%",
+			nArg: 2,
+			sig:  "num -> num -> num",
+			cb: func(args ...Literal) Literal {
+				checkDivisor(args[0], args[1])
+				return arith(args[0], args[1], remInt64, (*big.Int).Rem, math.Mod)
+			},
+		},
+		// div rounds toward negative infinity, so div (-7) 2 is -4.
+		"div": &funcSpec{
+			name: "div",
+			nArg: 2,
+			sig:  "Int -> Int -> Int",
 			cb: func(args ...Literal) Literal {
-				return NewLiteralInt(args[0].Int() * args[1].Int())
+				checkDivisor(args[0], args[1])
+				return intArith(args[0], args[1], divInt64, bigDiv)
+			},
+		},
+		// mod is the remainder of div, and its sign is the sign of the divisor,
+		// so mod (-7) 2 is 1.
+		"mod": &funcSpec{
+			name: "mod",
+			nArg: 2,
+			sig:  "Int -> Int -> Int",
+			cb: func(args ...Literal) Literal {
+				checkDivisor(args[0], args[1])
+				return intArith(args[0], args[1], modInt64, bigMod)
 			},
 		},
 		"builtin:==": &funcSpec{
 			name: "builtin:==",
 			nArg: 2,
+			sig:  "ord -> ord -> Bool",
 			cb: func(args ...Literal) (val Literal) {
 				val = kFalse
-				if args[0].Int() == args[1].Int() {
+				if compareLiterals(args[0], args[1]) == 0 {
 					val = kTrue
 				}
 				return
@@ -45,9 +108,10 @@ func init() {
 		"builtin:!=": &funcSpec{
 			name: "builtin:!=",
 			nArg: 2,
+			sig:  "ord -> ord -> Bool",
 			cb: func(args ...Literal) (val Literal) {
 				val = kFalse
-				if args[0].Int() != args[1].Int() {
+				if compareLiterals(args[0], args[1]) != 0 {
 					val = kTrue
 				}
 				return
@@ -56,9 +120,10 @@ func init() {
 		"builtin:>=": &funcSpec{
 			name: "builtin:>=",
 			nArg: 2,
+			sig:  "ord -> ord -> Bool",
 			cb: func(args ...Literal) (val Literal) {
 				val = kFalse
-				if args[0].Int() >= args[1].Int() {
+				if compareLiterals(args[0], args[1]) >= 0 {
 					val = kTrue
 				}
 				return
@@ -67,9 +132,10 @@ func init() {
 		"builtin:<=": &funcSpec{
 			name: "builtin:<=",
 			nArg: 2,
+			sig:  "ord -> ord -> Bool",
 			cb: func(args ...Literal) (val Literal) {
 				val = kFalse
-				if args[0].Int() <= args[1].Int() {
+				if compareLiterals(args[0], args[1]) <= 0 {
 					val = kTrue
 				}
 				return
@@ -78,9 +144,10 @@ func init() {
 		"builtin:<": &funcSpec{
 			name: "builtin:<",
 			nArg: 2,
+			sig:  "ord -> ord -> Bool",
 			cb: func(args ...Literal) (val Literal) {
 				val = kFalse
-				if args[0].Int() < args[1].Int() {
+				if compareLiterals(args[0], args[1]) < 0 {
 					val = kTrue
 				}
 				return
@@ -89,13 +156,286 @@ func init() {
 		"builtin:>": &funcSpec{
 			name: "builtin:>",
 			nArg: 2,
+			sig:  "ord -> ord -> Bool",
 			cb: func(args ...Literal) (val Literal) {
 				val = kFalse
-				if args[0].Int() > args[1].Int() {
+				if compareLiterals(args[0], args[1]) > 0 {
 					val = kTrue
 				}
 				return
 			},
 		},
+		"builtin:++": &funcSpec{
+			name: "builtin:++",
+			nArg: 2,
+			sig:  "String -> String -> String",
+			cb: func(args ...Literal) Literal {
+				return NewLiteralString(args[0].Str() + args[1].Str())
+			},
+		},
+		"not": &funcSpec{
+			name: "not",
+			nArg: 1,
+			sig:  "Bool -> Bool",
+			cb: func(args ...Literal) Literal {
+				if args[0].Bool() {
+					return kFalse
+				}
+				return kTrue
+			},
+		},
+		"negate": &funcSpec{
+			name: "negate",
+			nArg: 1,
+			sig:  "num -> num",
+			cb: func(args ...Literal) Literal {
+				return negateLiteral(args[0])
+			},
+		},
+		"clamp": &funcSpec{
+			name: "clamp",
+			nArg: 3,
+			sig:  "num -> num -> num -> num",
+			cb: func(args ...Literal) Literal {
+				lo, hi, x := args[0], args[1], args[2]
+				if compareLiterals(lo, hi) > 0 {
+					panic(fmt.Sprintf("clamp: %v is greater than %v", lo, hi))
+				}
+				if compareLiterals(x, lo) < 0 {
+					return lo
+				}
+				if compareLiterals(x, hi) > 0 {
+					return hi
+				}
+				return x
+			},
+		},
+		"strlen": &funcSpec{
+			name: "strlen",
+			nArg: 1,
+			sig:  "String -> Int",
+			cb: func(args ...Literal) Literal {
+				return NewLiteralInt(int64(utf8.RuneCountInString(args[0].Str())))
+			},
+		},
+		"strTake": &funcSpec{
+			name: "strTake",
+			nArg: 2,
+			sig:  "Int -> String -> String",
+			cb: func(args ...Literal) Literal {
+				s := args[1].Str()
+				return NewLiteralString(s[:runeOffset(s, args[0].Int())])
+			},
+		},
+		"strDrop": &funcSpec{
+			name: "strDrop",
+			nArg: 2,
+			sig:  "Int -> String -> String",
+			cb: func(args ...Literal) Literal {
+				s := args[1].Str()
+				return NewLiteralString(s[runeOffset(s, args[0].Int()):])
+			},
+		},
+		"substr": &funcSpec{
+			name: "substr",
+			nArg: 3,
+			sig:  "String -> Int -> Int -> String",
+			cb: func(args ...Literal) Literal {
+				s := args[0].Str()
+				s = s[runeOffset(s, args[1].Int()):]
+				return NewLiteralString(s[:runeOffset(s, args[2].Int())])
+			},
+		},
+		"strCons": &funcSpec{
+			name: "strCons",
+			nArg: 2,
+			sig:  "Char -> String -> String",
+			cb: func(args ...Literal) Literal {
+				return NewLiteralString(string(args[0].Char()) + args[1].Str())
+			},
+		},
+		"toChars": &funcSpec{
+			name: "toChars",
+			nArg: 1,
+			sig:  "String -> [Char]",
+			cb: func(args ...Literal) Literal {
+				var elems []Literal
+				for _, ch := range args[0].Str() {
+					elems = append(elems, NewLiteralChar(ch))
+				}
+				return newListValue(elems)
+			},
+		},
+		"show": &funcSpec{
+			name: "show",
+			nArg: 1,
+			sig:  "ord -> String",
+			cb: func(args ...Literal) Literal {
+				switch args[0].typ {
+				case LiteralInt, LiteralBigInt, LiteralFloat, LiteralBool, LiteralString, LiteralChar:
+					return NewLiteralString(args[0].String())
+				}
+				panic(fmt.Sprintf("cannot show %v", args[0]))
+			},
+		},
+		"sqrt": &funcSpec{
+			name: "sqrt",
+			nArg: 1,
+			sig:  "Float -> Float",
+			cb: func(args ...Literal) Literal {
+				return NewLiteralFloat(math.Sqrt(args[0].Float()))
+			},
+		},
+		"floor": &funcSpec{
+			name: "floor",
+			nArg: 1,
+			sig:  "Float -> Int",
+			cb: func(args ...Literal) Literal {
+				return floatToInt(math.Floor(args[0].Float()))
+			},
+		},
+		"ceil": &funcSpec{
+			name: "ceil",
+			nArg: 1,
+			sig:  "Float -> Int",
+			cb: func(args ...Literal) Literal {
+				return floatToInt(math.Ceil(args[0].Float()))
+			},
+		},
+		"truncate": &funcSpec{
+			name: "truncate",
+			nArg: 1,
+			sig:  "Float -> Int",
+			cb: func(args ...Literal) Literal {
+				return floatToInt(math.Trunc(args[0].Float()))
+			},
+		},
+		"toFloat": &funcSpec{
+			name: "toFloat",
+			nArg: 1,
+			sig:  "Int -> Float",
+			cb: func(args ...Literal) Literal {
+				return NewLiteralFloat(args[0].Float())
+			},
+		},
+		"ord": &funcSpec{
+			name: "ord",
+			nArg: 1,
+			sig:  "Char -> Int",
+			cb: func(args ...Literal) Literal {
+				return NewLiteralInt(int64(args[0].Char()))
+			},
+		},
+		"chr": &funcSpec{
+			name: "chr",
+			nArg: 1,
+			sig:  "Int -> Char",
+			cb: func(args ...Literal) Literal {
+				return NewLiteralChar(rune(args[0].Int()))
+			},
+		},
+	}
+	for _, f := range funcs {
+		f.sigType = parseTypeSig(f.sig)
+	}
+}
+
+// arith applies an arithmetic operator. If either operand is a float, the other
+// one is converted to a float, and floatOp computes the result. Otherwise, both
+// operands must be ints, and the result is computed by intOp, or by bigOp if the
+// result or an operand doesn't fit in int64.
+func arith(a, b Literal,
+	intOp func(x, y int64) (int64, bool),
+	bigOp func(z, x, y *big.Int) *big.Int,
+	floatOp func(x, y float64) float64) Literal {
+	if a.typ == LiteralFloat || b.typ == LiteralFloat {
+		return NewLiteralFloat(floatOp(a.Float(), b.Float()))
+	}
+	return intArith(a, b, intOp, bigOp)
+}
+
+// checkDivisor panics with ErrDivisionByZero if a and b are ints and b is
+// zero. Float division by zero follows IEEE 754.
+func checkDivisor(a, b Literal) {
+	if isInt(a) && isInt(b) && b.Big().Sign() == 0 {
+		panic(ErrDivisionByZero)
+	}
+}
+
+// negateLiteral returns -v. V must be a number.
+func negateLiteral(v Literal) Literal {
+	switch v.typ {
+	case LiteralFloat:
+		return NewLiteralFloat(-v.floatVal)
+	case LiteralInt, LiteralBigInt:
+		return intArith(NewLiteralInt(0), v, subInt64, (*big.Int).Sub)
+	}
+	panic("expect a number, but found " + v.String())
+}
+
+// floatToInt converts an integral float to an int. It panics if the value is
+// infinite or NaN.
+func floatToInt(f float64) Literal {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		panic(fmt.Sprintf("cannot convert %v to an int", NewLiteralFloat(f)))
+	}
+	if f >= math.MinInt64 && f < math.MaxInt64 {
+		return NewLiteralInt(int64(f))
+	}
+	v, _ := big.NewFloat(f).Int(nil)
+	return newLiteralBig(v)
+}
+
+// isNumber checks if the value is an int or a float.
+func isNumber(l Literal) bool { return isInt(l) || l.typ == LiteralFloat }
+
+// compareLiterals returns a negative number, zero, or a positive number if a is
+// less than, equal to, or greater than b, respectively. It panics unless a and b
+// are ints, bools, chars, or strings of the same type, or numbers. An int
+// compared with a float is converted to a float.
+func compareLiterals(a, b Literal) int {
+	if a.typ != b.typ && isNumber(a) && isNumber(b) {
+		if isInt(a) && isInt(b) {
+			return a.Big().Cmp(b.Big())
+		}
+		a, b = NewLiteralFloat(a.Float()), NewLiteralFloat(b.Float())
+	}
+	if a.typ != b.typ {
+		panic(fmt.Sprintf("cannot compare %v and %v", a, b))
+	}
+	switch a.typ {
+	case LiteralInt, LiteralBool, LiteralChar:
+		switch {
+		case a.intVal < b.intVal:
+			return -1
+		case a.intVal > b.intVal:
+			return 1
+		}
+		return 0
+	case LiteralBigInt:
+		return a.bigVal.Cmp(b.bigVal)
+	case LiteralFloat:
+		switch {
+		case a.floatVal < b.floatVal:
+			return -1
+		case a.floatVal > b.floatVal:
+			return 1
+		}
+		return 0
+	case LiteralString:
+		return strings.Compare(a.strVal, b.strVal)
+	}
+	panic(fmt.Sprintf("cannot compare %v and %v", a, b))
+}
+
+// runeOffset returns the byte offset of the n'th character in s. N is clamped
+// to [0, number of characters in s].
+func runeOffset(s string, n int64) int {
+	for i := range s {
+		if n <= 0 {
+			return i
+		}
+		n--
 	}
+	return len(s)
 }
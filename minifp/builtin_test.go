@@ -0,0 +1,69 @@
+package minifp_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+func TestBuiltinArity(t *testing.T) {
+	km := minifp.NewMachine()
+	for _, test := range []struct{ src, want string }{
+		{`not (1 == 2)`, "true"},
+		{`map not [1 == 1, 1 == 2]`, "[false, true]"},
+		{`negate 3`, "-3"},
+		{`negate (0.0 - 1.5)`, "1.5"},
+		{`negate (0 - 9223372036854775807 - 1)`, "9223372036854775808"},
+		{`clamp 0 10 15`, "10"},
+		{`clamp 0 10 (0 - 5)`, "0"},
+		{`clamp 0 10 2.5`, "2.5"},
+		{`map (clamp 1 3) [0, 2, 4]`, "[1, 2, 3]"},
+		{`substr "héllo" 1 3`, `"éll"`},
+		{`(\f -> f 1 2) (substr "abcd")`, `"bc"`},
+		// The arguments are evaluated from left to right, and only once.
+		{`(\x -> clamp x (x + 10) (x * 3)) (strlen "abcd")`, "12"},
+	} {
+		expect.EQ(t, run(t, km, test.src).String(), test.want, test.src)
+	}
+
+	x := minifp.Parse(strings.NewReader(`clamp 3 1 2`))
+	_, err := km.RunErr(km.Compile(x[0]))
+	expect.HasSubstr(t, err.Error(), "clamp: 3 is greater than 1")
+
+	for _, test := range []struct{ src, want string }{
+		{`not`, "Bool -> Bool"},
+		{`negate 1.5`, "Float"},
+		{`clamp 1 2`, "Int -> Int"},
+		{`substr "ab" 1`, "Int -> String"},
+	} {
+		got, err := typeOf(t, test.src)
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, got, test.want, test.src)
+	}
+}
+
+func TestRegisterFuncArity(t *testing.T) {
+	km := minifp.NewMachine()
+	var n int64
+	expect.NoError(t, km.RegisterFunc("counter", 0, func(args ...minifp.Value) (minifp.Value, error) {
+		n++
+		return minifp.NewLiteralInt(n), nil
+	}))
+	expect.NoError(t, km.RegisterFunc("sum4", 4, func(args ...minifp.Value) (minifp.Value, error) {
+		var sum int64
+		for _, arg := range args {
+			sum = sum*10 + arg.Int()
+		}
+		return minifp.NewLiteralInt(sum), nil
+	}))
+	expect.NoError(t, km.RegisterGoFunc("pi", func() float64 { return 3.25 }))
+	expect.NoError(t, km.RegisterGoFunc("between", func(lo, hi, x int) bool { return lo <= x && x < hi }))
+	expect.EQ(t, run(t, km, `counter`).String(), "1")
+	expect.EQ(t, run(t, km, `counter + counter`).String(), "5")
+	expect.EQ(t, run(t, km, `sum4 1 2 3 4`).String(), "1234")
+	expect.EQ(t, run(t, km, `foldr (\f acc -> f acc) 0 [sum4 1 2 3]`).String(), "1230")
+	expect.EQ(t, run(t, km, `pi * 2`).String(), "6.5")
+	expect.EQ(t, run(t, km, `filter (between 2 4) (range 0 10)`).String(), "[2, 3]")
+}
@@ -0,0 +1,272 @@
+package minifp
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+)
+
+// Data is the Go representation of a value of a user-defined data type, e.g.,
+// "Pair 1 2" is Data{Con: "Pair", Fields: []interface{}{int64(1), int64(2)}}.
+type Data struct {
+	Con    string
+	Fields []interface{}
+}
+
+// Call is CallContext with a background context.
+func (k *KMachine) Call(name string, args ...interface{}) (interface{}, error) {
+	return k.CallContext(context.Background(), name, args...)
+}
+
+// CallContext applies the global function of the given name to args, and
+// returns the result. The arguments are converted to minifp values as
+// follows:
+//
+//	int, int8, ..., uint64, *big.Int -> Int
+//	float32, float64                 -> Float
+//	bool                             -> Bool
+//	string                           -> String
+//	rune                             -> Char
+//	slice, array                     -> list
+//	map                              -> list of Pairs, sorted by key
+//	Data                             -> constructor application
+//	Literal                          -> as is
+//
+// The result is evaluated fully, then converted back to Go: Int becomes int64,
+// or *big.Int if it doesn't fit; Float becomes float64; Bool, String, and Char
+// become bool, string, and rune; a list becomes []interface{}; and other data
+// becomes Data. It is an error if the result is, or contains, a function.
+//
+// The function is applied lazily, so it may ignore the arguments it doesn't
+// use. Evaluation errors are reported as in RunContext.
+func (k *KMachine) CallContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	addr, ok := k.lookupGlobal(InternSymbol(name))
+	if !ok {
+		return nil, fmt.Errorf("call %s: global not found", name)
+	}
+	cls := make([]KClosure, len(args))
+	for i, arg := range args {
+		val, err := k.fromGo(reflect.ValueOf(arg))
+		if err != nil {
+			return nil, fmt.Errorf("call %s: arg %d: %v", name, i, err)
+		}
+		cls[i] = valueClosure(val)
+	}
+	val, err := k.run(ctx, &KVar{pos: kUnknownPos, Addr: addr}, cls, 0)
+	if err != nil {
+		return nil, err
+	}
+	result, err := toGo(val, map[*conValue]bool{})
+	if err != nil {
+		return nil, fmt.Errorf("call %s: %v", name, err)
+	}
+	return result, nil
+}
+
+// lookupGlobal finds the global variable of the given name.
+func (k *KMachine) lookupGlobal(sym Symbol) (KAddr, bool) {
+	for i := len(k.Globals) - 1; i >= 0; i-- {
+		if k.Globals[i].sym == sym {
+			return KAddr{frameIndex: kGlobalFrame, varIndex: uint32(i)}, true
+		}
+	}
+	return KAddr{}, false
+}
+
+var dataType = reflect.TypeOf(Data{})
+
+// fromGo converts a Go value to a minifp value. See CallContext for the
+// conversion rules.
+func (k *KMachine) fromGo(v reflect.Value) (Literal, error) {
+	if !v.IsValid() {
+		return Literal{}, fmt.Errorf("cannot convert nil")
+	}
+	switch v.Type() {
+	case literalType:
+		return v.Interface().(Literal), nil
+	case bigIntType:
+		if v.IsNil() {
+			return Literal{}, fmt.Errorf("cannot convert nil")
+		}
+		return toLiteral(v), nil
+	case reflect.TypeOf(rune(0)):
+		return NewLiteralChar(rune(v.Int())), nil
+	case dataType:
+		return k.fromGoData(v.Interface().(Data))
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewLiteralInt(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return newLiteralBig(new(big.Int).SetUint64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return NewLiteralFloat(v.Float()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return kTrue, nil
+		}
+		return kFalse, nil
+	case reflect.String:
+		return NewLiteralString(v.String()), nil
+	case reflect.Slice, reflect.Array:
+		elems := make([]Literal, v.Len())
+		for i := range elems {
+			elem, err := k.fromGo(v.Index(i))
+			if err != nil {
+				return Literal{}, err
+			}
+			elems[i] = elem
+		}
+		return newListValue(elems), nil
+	case reflect.Map:
+		return k.fromGoMap(v)
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return Literal{}, fmt.Errorf("cannot convert nil")
+		}
+		return k.fromGo(v.Elem())
+	}
+	return Literal{}, fmt.Errorf("cannot convert %v", v.Type())
+}
+
+// fromGoData converts a Data to a constructor value.
+func (k *KMachine) fromGoData(d Data) (Literal, error) {
+	spec, ok := k.cons[InternSymbol(d.Con)]
+	if !ok {
+		return Literal{}, fmt.Errorf("constructor %s not found", d.Con)
+	}
+	if spec.arity != len(d.Fields) {
+		return Literal{}, fmt.Errorf("constructor %s takes %d fields, but found %d", d.Con, spec.arity, len(d.Fields))
+	}
+	if spec == consSpec {
+		return Literal{}, fmt.Errorf("use a slice to create a list")
+	}
+	con := &conValue{spec: spec, fields: make([]*kVarEntry, spec.arity)}
+	for i, field := range d.Fields {
+		val, err := k.fromGo(reflect.ValueOf(field))
+		if err != nil {
+			return Literal{}, err
+		}
+		con.fields[i] = &kVarEntry{sym: InternSymbol(fmt.Sprintf("$%d", i)), cl: valueClosure(val)}
+	}
+	return Literal{typ: LiteralCon, con: con}, nil
+}
+
+// fromGoMap converts a map to a list of Pairs, sorted by key.
+func (k *KMachine) fromGoMap(v reflect.Value) (Literal, error) {
+	type entry struct{ key, val Literal }
+	var entries []entry
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := k.fromGo(iter.Key())
+		if err != nil {
+			return Literal{}, err
+		}
+		val, err := k.fromGo(iter.Value())
+		if err != nil {
+			return Literal{}, err
+		}
+		entries = append(entries, entry{key, val})
+	}
+	var err error
+	sort.Slice(entries, func(i, j int) bool {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("cannot sort map keys: %v", r)
+			}
+		}()
+		return compareLiterals(entries[i].key, entries[j].key) < 0
+	})
+	if err != nil {
+		return Literal{}, err
+	}
+	elems := make([]Literal, len(entries))
+	for i, e := range entries {
+		// Use pairSpec even if Pair has been redefined.
+		elems[i] = Literal{typ: LiteralCon, con: &conValue{spec: pairSpec, fields: []*kVarEntry{
+			{sym: InternSymbol("$0"), cl: valueClosure(e.key)},
+			{sym: InternSymbol("$1"), cl: valueClosure(e.val)},
+		}}}
+	}
+	return newListValue(elems), nil
+}
+
+// toGo converts a fully evaluated minifp value to a Go value. See CallContext
+// for the conversion rules. Visiting holds the constructor values being
+// converted, to detect cycles.
+func toGo(val Literal, visiting map[*conValue]bool) (interface{}, error) {
+	switch val.typ {
+	case LiteralInt:
+		return val.intVal, nil
+	case LiteralBigInt:
+		return new(big.Int).Set(val.bigVal), nil
+	case LiteralFloat:
+		return val.floatVal, nil
+	case LiteralBool:
+		return val.Bool(), nil
+	case LiteralString:
+		return val.strVal, nil
+	case LiteralChar:
+		return val.Char(), nil
+	case LiteralCon:
+		if val.con.spec == nilSpec || val.con.spec == consSpec {
+			return listToGo(val.con, visiting)
+		}
+		if visiting[val.con] {
+			return nil, fmt.Errorf("cannot convert a cyclic value")
+		}
+		visiting[val.con] = true
+		defer delete(visiting, val.con)
+		fields := make([]interface{}, len(val.con.fields))
+		for i, f := range val.con.fields {
+			field, err := fieldToGo(f.cl, visiting)
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = field
+		}
+		return Data{Con: val.con.spec.sym.String(), Fields: fields}, nil
+	case LiteralFunc:
+		return nil, fmt.Errorf("cannot convert a function")
+	}
+	return nil, fmt.Errorf("cannot convert %v", val)
+}
+
+// listToGo converts a list that starts at c to a slice.
+func listToGo(c *conValue, visiting map[*conValue]bool) (interface{}, error) {
+	elems := []interface{}{}
+	var cells []*conValue
+	defer func() {
+		for _, c := range cells {
+			delete(visiting, c)
+		}
+	}()
+	for c.spec == consSpec {
+		if visiting[c] {
+			return nil, fmt.Errorf("cannot convert a cyclic value")
+		}
+		visiting[c] = true
+		cells = append(cells, c)
+		elem, err := fieldToGo(c.fields[0].cl, visiting)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+		tail := c.fields[1].cl
+		if tail.Code != kRet {
+			return nil, fmt.Errorf("cannot convert a function")
+		}
+		c = tail.Env.Const.con
+	}
+	return elems, nil
+}
+
+// fieldToGo converts a field of a fully evaluated constructor value.
+func fieldToGo(cl KClosure, visiting map[*conValue]bool) (interface{}, error) {
+	if cl.Code != kRet {
+		return nil, fmt.Errorf("cannot convert a function")
+	}
+	return toGo(*cl.Env.Const, visiting)
+}
@@ -0,0 +1,103 @@
+package minifp_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+func TestCall(t *testing.T) {
+	km := minifp.NewMachine()
+	run(t, km, `data Decision = Allow | Deny String`)
+	run(t, km, `check user n = if (n > 3) (Deny (user ++ ": too many requests")) Allow`)
+	run(t, km, `sumPairs ps = case ps of {[] -> 0; Pair _ v : rest -> v + sumPairs rest}`)
+	run(t, km, `keys ps = map (\p -> case p of {Pair k _ -> k}) ps`)
+	run(t, km, `const x y = x`)
+	run(t, km, `answer = 6 * 7`)
+
+	for _, test := range []struct {
+		name string
+		args []interface{}
+		want interface{}
+	}{
+		{"answer", nil, int64(42)},
+		{"length", []interface{}{[]string{"a", "b", "c"}}, int64(3)},
+		{"take", []interface{}{2, []int{5, 6, 7}}, []interface{}{int64(5), int64(6)}},
+		{"range", []interface{}{uint8(1), 3}, []interface{}{int64(1), int64(2)}},
+		{"zip", []interface{}{[]bool{true}, []rune("ab")},
+			[]interface{}{minifp.Data{Con: "Pair", Fields: []interface{}{true, 'a'}}}},
+		{"check", []interface{}{"alice", 2}, minifp.Data{Con: "Allow", Fields: []interface{}{}}},
+		{"check", []interface{}{"bob", 5}, minifp.Data{Con: "Deny", Fields: []interface{}{"bob: too many requests"}}},
+		{"sumPairs", []interface{}{map[string]float64{"a": 1.5, "b": 2}}, 3.5},
+		{"keys", []interface{}{map[string]int{"z": 1, "a": 2, "m": 3}}, []interface{}{"a", "m", "z"}},
+		{"fromChars", []interface{}{[]interface{}{'h', 'i'}}, "hi"},
+		{"const", []interface{}{minifp.Data{Con: "Deny", Fields: []interface{}{"x"}}, 0},
+			minifp.Data{Con: "Deny", Fields: []interface{}{"x"}}},
+		{"const", []interface{}{uint64(1) << 63, 0}, new(big.Int).Lsh(big.NewInt(1), 63)},
+	} {
+		got, err := km.Call(test.name, test.args...)
+		expect.NoError(t, err, test.name)
+		expect.EQ(t, got, test.want, test.name)
+	}
+
+	// The function is applied lazily.
+	got, err := km.Call("const", 1, []interface{}{1, "x", 2.5})
+	expect.NoError(t, err)
+	expect.EQ(t, got, int64(1))
+
+	// MaxForce doesn't apply to the result.
+	km = minifp.NewMachine(minifp.MaxForce(1))
+	got, err = km.Call("range", 1, 4)
+	expect.NoError(t, err)
+	expect.EQ(t, got, []interface{}{int64(1), int64(2), int64(3)})
+}
+
+func TestCallError(t *testing.T) {
+	km := minifp.NewMachine(minifp.MaxSteps(10000))
+	run(t, km, `data Box = Box (Int -> Int)`)
+	run(t, km, `boxed x = Box (\y -> x + y)`)
+	run(t, km, `loop x = loop x`)
+	run(t, km, `ones = 1 : ones`)
+
+	for _, test := range []struct {
+		name string
+		args []interface{}
+		want string
+	}{
+		{"noSuchFunc", nil, "call noSuchFunc: global not found"},
+		{"length", []interface{}{nil}, "call length: arg 0: cannot convert nil"},
+		{"length", []interface{}{[]interface{}{struct{}{}}}, "call length: arg 0: cannot convert struct {}"},
+		{"length", []interface{}{minifp.Data{Con: "Nope"}}, "constructor Nope not found"},
+		{"length", []interface{}{minifp.Data{Con: "Box"}}, "constructor Box takes 1 fields, but found 0"},
+		{"length", []interface{}{map[interface{}]int{1: 1, "a": 2}}, "cannot sort map keys"},
+		{"map", []interface{}{minifp.Literal{}}, "call map: cannot convert a function"},
+		{"boxed", []interface{}{1}, "call boxed: cannot convert a function"},
+		{"ones", nil, "call ones: cannot convert a cyclic value"},
+		{"strlen", []interface{}{"a"}, "call strlen: global not found"},
+	} {
+		_, err := km.Call(test.name, test.args...)
+		expect.True(t, err != nil, test.name)
+		if err != nil {
+			expect.HasSubstr(t, err.Error(), test.want, test.name)
+		}
+	}
+
+	_, err := km.Call("loop", 1)
+	expect.True(t, errors.Is(err, minifp.ErrStepLimit), err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	km = minifp.NewMachine()
+	run(t, km, `loop x = loop x`)
+	_, err = km.CallContext(ctx, "loop", 1)
+	expect.True(t, errors.Is(err, context.Canceled), err)
+
+	// The machine remains usable.
+	got, err := km.Call("length", []int{1, 2})
+	expect.NoError(t, err)
+	expect.EQ(t, got, int64(2))
+}
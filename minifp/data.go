@@ -0,0 +1,427 @@
+package minifp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/scanner"
+)
+
+// conSpec describes a data constructor declared by ASTData.
+type conSpec struct {
+	sym   Symbol
+	tag   int
+	arity int
+}
+
+// Pair is a builtin data type, "data Pair a b = Pair a b". Unlike the data
+// types of the prelude, it is available without the prelude, since CallContext
+// converts a Go map to a list of Pairs.
+var pairSpec = &conSpec{sym: InternSymbol("Pair"), tag: 0, arity: 2}
+
+// pairTypeName is the name of the Pair type constructor.
+const pairTypeName = "Pair"
+
+// pairConType returns the type of the Pair constructor, "a -> b -> Pair a b".
+func pairConType() Type {
+	a, b := &TypeVar{level: genericLevel}, &TypeVar{level: genericLevel}
+	return newFuncType(a, newFuncType(b, &TypeCon{Name: pairTypeName, Args: []Type{a, b}}))
+}
+
+// conValue is a constructor applied to its arguments. The fields are lazy; they
+// are updated in place when forced, so that all references to the value share
+// the evaluation.
+type conValue struct {
+	spec   *conSpec
+	fields []*kVarEntry
+}
+
+func (c *conValue) String() string {
+	var buf strings.Builder
+	c.format(&buf, false, map[*conValue]bool{})
+	return buf.String()
+}
+
+// format renders the value. If nested is true and the constructor has fields,
+// the value is parenthesized. Visiting records the values being rendered to
+// detect cycles.
+func (c *conValue) format(buf *strings.Builder, nested bool, visiting map[*conValue]bool) {
+	if len(c.fields) == 0 {
+		buf.WriteString(c.spec.sym.String())
+		return
+	}
+	if visiting[c] || c.unforced() {
+		buf.WriteString("...")
+		return
+	}
+	if c.spec == consSpec {
+		formatList(buf, c, nested, visiting)
+		return
+	}
+	visiting[c] = true
+	if nested {
+		buf.WriteRune('(')
+	}
+	buf.WriteString(c.spec.sym.String())
+	for _, f := range c.fields {
+		buf.WriteRune(' ')
+		formatField(buf, f.cl, true, visiting)
+	}
+	if nested {
+		buf.WriteRune(')')
+	}
+	delete(visiting, c)
+}
+
+// unforced checks if none of the fields of c has been evaluated, e.g., because
+// of MaxForce.
+func (c *conValue) unforced() bool {
+	for _, f := range c.fields {
+		if _, ok := f.cl.Code.(*KLambda); ok || f.cl.Code == kRet {
+			return false
+		}
+	}
+	return true
+}
+
+// formatField renders the value of a constructor field. If nested is true, a
+// constructor value with fields is parenthesized. A field that hasn't been
+// evaluated, e.g., because of MaxForce, is rendered as "...".
+func formatField(buf *strings.Builder, cl KClosure, nested bool, visiting map[*conValue]bool) {
+	if cl.Code != kRet {
+		if _, ok := cl.Code.(*KLambda); ok {
+			buf.WriteString("<function>")
+		} else {
+			buf.WriteString("...")
+		}
+		return
+	}
+	val := *cl.Env.Const
+	if val.con != nil {
+		val.con.format(buf, nested, visiting)
+		return
+	}
+	buf.WriteString(val.String())
+}
+
+// KConstruct creates a constructor value. The fields are the variables at
+// Fields, which are the arguments of the enclosing KLambdas.
+type KConstruct struct {
+	pos    scanner.Position
+	Con    *conSpec
+	Fields []KAddr
+}
+
+func (k *KConstruct) Pos() scanner.Position { return k.pos }
+func (k *KConstruct) DebugString() string {
+	return "construct:" + k.Con.sym.String()
+}
+
+// KCaseAlt is one alternative of KCase. Exactly one of Con and Lit is set.
+type KCaseAlt struct {
+	Con  *conSpec
+	Lit  *Literal
+	Body KCode
+}
+
+// KCase inspects the value on the top of the stack, and runs the body of the
+// first alternative that matches the value. If the value is a constructor, the
+// body runs in a new frame that holds the fields. If no alternative matches,
+// KCase runs Default.
+type KCase struct {
+	pos     scanner.Position
+	Alts    []KCaseAlt
+	Default KCode
+}
+
+func (k *KCase) Pos() scanner.Position { return k.pos }
+func (k *KCase) DebugString() string {
+	var buf strings.Builder
+	buf.WriteString("case{")
+	for i, alt := range k.Alts {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		if alt.Con != nil {
+			buf.WriteString(alt.Con.sym.String())
+		} else {
+			buf.WriteString(alt.Lit.String())
+		}
+		buf.WriteString(" -> ")
+		buf.WriteString(alt.Body.DebugString())
+	}
+	buf.WriteString("; _ -> ")
+	buf.WriteString(k.Default.DebugString())
+	buf.WriteRune('}')
+	return buf.String()
+}
+
+// KFail raises a runtime error.
+type KFail struct {
+	pos scanner.Position
+	Msg string
+}
+
+func (k *KFail) Pos() scanner.Position { return k.pos }
+func (k *KFail) DebugString() string {
+	return fmt.Sprintf("fail:%q", k.Msg)
+}
+
+func (k *KMachine) stepConstruct(v *KConstruct) {
+	if len(v.Fields) != v.Con.arity {
+		k.failf("construct: expect %d fields, but found %d", v.Con.arity, len(v.Fields))
+	}
+	fields := make([]*kVarEntry, v.Con.arity)
+	for i, addr := range v.Fields {
+		fields[i] = k.readVar(addr)
+	}
+	val := Literal{typ: LiteralCon, con: &conValue{spec: v.Con, fields: fields}}
+	frame := k.newFrame(kEnvFrame{Const: &val})
+	k.Code = kRet
+	k.Locals = frame
+}
+
+func (k *KMachine) stepCase(v *KCase) {
+	val := k.peekValue(0)
+	for _, alt := range v.Alts {
+		if alt.Con != nil {
+			if val.con == nil || val.con.spec != alt.Con {
+				continue
+			}
+			frame := k.newFrame(kEnvFrame{vars: val.con.fields, next: k.Locals})
+			k.popStack()
+			k.Code = alt.Body
+			k.Locals = frame
+			return
+		}
+		if matchLiteral(val, *alt.Lit) {
+			k.popStack()
+			k.Code = alt.Body
+			return
+		}
+	}
+	k.popStack()
+	k.Code = v.Default
+}
+
+// matchLiteral checks if val equals the literal pattern lit. A number pattern
+// matches an equal int or float.
+func matchLiteral(val, lit Literal) bool {
+	if val.typ != lit.typ && !(isNumber(val) && isNumber(lit)) {
+		return false
+	}
+	return compareLiterals(val, lit) == 0
+}
+
+// force evaluates the fields of constructor values reachable from val,
+// breadth-first. If limit is positive, the fields of at most limit values are
+// evaluated.
+func (k *KMachine) force(ctx context.Context, val Literal, limit int) {
+	if val.con == nil {
+		return
+	}
+	var (
+		visited = map[*conValue]bool{val.con: true}
+		work    = []*conValue{val.con}
+	)
+	for n := 0; n < len(work) && (limit <= 0 || n < limit); n++ {
+		c := work[n]
+		for i := range c.fields {
+			e := c.fields[i]
+			if e.cl.Code != kRet {
+				k.Stack = k.Stack[:0]
+				k.enter(e)
+				k.eval(ctx)
+			}
+			if e.cl.Code == kRet {
+				// Otherwise, the field is a function.
+				if fc := e.cl.Env.Const.con; fc != nil && len(fc.fields) > 0 && !visited[fc] {
+					visited[fc] = true
+					work = append(work, fc)
+				}
+			}
+		}
+	}
+}
+
+// compileData registers the constructors declared by the node.
+func (c *compiler) compileData(v *ASTData) KCode {
+	for i, decl := range v.Cons {
+		c.cons[decl.Sym] = &conSpec{sym: decl.Sym, tag: i, arity: len(decl.Fields)}
+	}
+	return &KConst{pos: v.pos, Val: kNil}
+}
+
+// compileCon compiles a reference to a constructor. A constructor of arity n is
+// a function of n arguments.
+func (c *compiler) compileCon(v *ASTCon) KCode {
+	spec, ok := c.cons[v.Sym]
+	if !ok {
+		panicf(v.pos, "constructor %v not found", v.Sym)
+	}
+	args := make([]Symbol, spec.arity)
+	for i := range args {
+		args[i] = InternSymbol(fmt.Sprintf("$%d", i))
+	}
+	var build func(i int) KCode
+	build = func(i int) KCode {
+		if i < len(args) {
+			return c.compileLambda(v.pos, args[i], func() KCode { return build(i + 1) })
+		}
+		k := &KConstruct{pos: v.pos, Con: spec, Fields: make([]KAddr, len(args))}
+		for j, arg := range args {
+			k.Fields[j], _ = c.lookup(v.pos, arg)
+		}
+		return k
+	}
+	return build(0)
+}
+
+// compileCase compiles a case expression. The scrutinee is bound to a fresh
+// variable, and the alternatives are tried in order. A nested pattern is
+// compiled to nested KCases; if it fails to match, control passes to a thunk
+// that tries the remaining alternatives.
+func (c *compiler) compileCase(v *ASTCase) KCode {
+	scrutinee := c.freshSym("$s")
+	return c.letrec(v.pos, []Symbol{scrutinee},
+		[]func() KCode{func() KCode { return c.compile(v.Expr) }},
+		func() KCode { return c.compileAlts(v, scrutinee, v.Alts) })
+}
+
+func (c *compiler) compileAlts(v *ASTCase, scrutinee Symbol, alts []ASTCaseAlt) KCode {
+	if len(alts) == 0 {
+		return &KFail{pos: v.pos, Msg: "non-exhaustive patterns in case"}
+	}
+	if flat := c.compileFlatAlts(v, scrutinee, alts); flat != nil {
+		return flat
+	}
+	// Bind the remaining alternatives to a thunk, and try the first one.
+	fail := c.freshSym("$fail")
+	return c.letrec(v.pos, []Symbol{fail},
+		[]func() KCode{func() KCode { return c.compileAlts(v, scrutinee, alts[1:]) }},
+		func() KCode {
+			return c.compilePattern(alts[0].Pat, scrutinee,
+				func() KCode { return c.compile(alts[0].Body) },
+				func() KCode { return c.compileVar(v.pos, fail) })
+		})
+}
+
+// compileFlatAlts compiles alternatives into a single KCase if none of the
+// patterns are nested. Otherwise it returns nil.
+func (c *compiler) compileFlatAlts(v *ASTCase, scrutinee Symbol, alts []ASTCaseAlt) KCode {
+	isVar := func(p ASTPattern) bool {
+		switch p.(type) {
+		case *ASTPatVar, *ASTPatWildcard:
+			return true
+		}
+		return false
+	}
+	for _, alt := range alts {
+		if p, ok := alt.Pat.(*ASTPatCon); ok {
+			for _, arg := range p.Args {
+				if !isVar(arg) {
+					return nil
+				}
+			}
+		}
+	}
+	return c.apply(v.pos, c.compileVar(v.pos, scrutinee), func() KCode {
+		k := &KCase{pos: v.pos}
+		for _, alt := range alts {
+			alt := alt
+			body := func() KCode { return c.compile(alt.Body) }
+			switch p := alt.Pat.(type) {
+			case *ASTPatCon:
+				spec := c.lookupCon(p)
+				k.Alts = append(k.Alts, KCaseAlt{Con: spec, Body: c.withFields(p, nil, body)})
+				continue
+			case *ASTPatLiteral:
+				lit := p.Val
+				k.Alts = append(k.Alts, KCaseAlt{Lit: &lit, Body: body()})
+				continue
+			}
+			// A variable or a wildcard matches everything, so the remaining
+			// alternatives are unreachable.
+			k.Default = c.compilePattern(alt.Pat, scrutinee, body, nil)
+			break
+		}
+		if k.Default == nil {
+			k.Default = &KFail{pos: v.pos, Msg: "non-exhaustive patterns in case"}
+		}
+		return k
+	})
+}
+
+// compilePattern generates code that matches the value of variable sym against
+// the pattern. It runs success() if the pattern matches, and fail() otherwise.
+// Success() is compiled in the scope of the variables bound by the pattern.
+func (c *compiler) compilePattern(pat ASTPattern, sym Symbol, success, fail func() KCode) KCode {
+	switch p := pat.(type) {
+	case *ASTPatWildcard:
+		return success()
+	case *ASTPatVar:
+		return c.letrec(p.pos, []Symbol{p.Sym},
+			[]func() KCode{func() KCode { return c.compileVar(p.pos, sym) }},
+			success)
+	case *ASTPatLiteral:
+		lit := p.Val
+		return c.apply(p.pos, c.compileVar(p.pos, sym), func() KCode {
+			return &KCase{pos: p.pos, Alts: []KCaseAlt{{Lit: &lit, Body: success()}}, Default: fail()}
+		})
+	case *ASTPatCon:
+		spec := c.lookupCon(p)
+		return c.apply(p.pos, c.compileVar(p.pos, sym), func() KCode {
+			return &KCase{
+				pos:     p.pos,
+				Alts:    []KCaseAlt{{Con: spec, Body: c.withFields(p, fail, success)}},
+				Default: fail(),
+			}
+		})
+	}
+	panic(pat)
+}
+
+// withFields compiles the body of a KCase alternative for constructor pattern
+// p. The fields are bound to the variables in p, or to fresh variables if the
+// argument patterns are nested; the nested patterns are then matched in turn.
+func (c *compiler) withFields(p *ASTPatCon, fail, success func() KCode) KCode {
+	frame := make([]Symbol, len(p.Args))
+	for i, arg := range p.Args {
+		if v, ok := arg.(*ASTPatVar); ok {
+			frame[i] = v.Sym
+		} else {
+			frame[i] = c.freshSym("$f")
+		}
+	}
+	c.pushFrame(frame)
+	defer c.popFrame()
+	var match func(i int) KCode
+	match = func(i int) KCode {
+		for ; i < len(p.Args); i++ {
+			switch p.Args[i].(type) {
+			case *ASTPatVar, *ASTPatWildcard:
+				continue
+			}
+			return c.compilePattern(p.Args[i], frame[i], func() KCode { return match(i + 1) }, fail)
+		}
+		return success()
+	}
+	return match(0)
+}
+
+func (c *compiler) lookupCon(p *ASTPatCon) *conSpec {
+	spec, ok := c.cons[p.Sym]
+	if !ok {
+		panicf(p.pos, "constructor %v not found", p.Sym)
+	}
+	if spec.arity != len(p.Args) {
+		panicf(p.pos, "constructor %v takes %d arguments, but the pattern has %d", p.Sym, spec.arity, len(p.Args))
+	}
+	return spec
+}
+
+// freshSym creates a symbol that doesn't conflict with user-defined ones.
+func (c *compiler) freshSym(prefix string) Symbol {
+	c.nFresh++
+	return InternSymbol(fmt.Sprintf("%s%d", prefix, c.nFresh))
+}
@@ -0,0 +1,124 @@
+package minifp_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+const listDecls = `data IntList = Nil | Cons Int IntList;
+data Maybe a = Nothing | Just a;
+data Pair a b = Pair a b;
+`
+
+func TestDataConstructor(t *testing.T) {
+	km := minifp.NewMachine()
+	expect.EQ(t, run(t, km, listDecls+`Nothing`).String(), "Nothing")
+	expect.EQ(t, run(t, km, `Just (1+2)`).String(), "Just 3")
+	expect.EQ(t, run(t, km, `Pair (Just 1) (2 == 2)`).String(), "Pair (Just 1) true")
+	expect.EQ(t, run(t, km, `Cons 1 (Cons 2 Nil)`).String(), "Cons 1 (Cons 2 Nil)")
+	// Partial application of a constructor.
+	expect.EQ(t, run(t, km, `(\f -> f 2) (Pair 1)`).String(), "Pair 1 2")
+	expect.EQ(t, run(t, km, `Just (\x -> x)`).String(), "Just <function>")
+}
+
+func TestCase(t *testing.T) {
+	km := minifp.NewMachine()
+	run(t, km, listDecls+`
+fromMaybe d m = case m of {Nothing -> d; Just x -> x};
+sum xs = case xs of {Nil -> 0; Cons x rest -> x + sum rest};
+isZero n = case n of {0 -> 1 == 1; _ -> 1 == 0};
+firstTwo xs = case xs of {Cons a (Cons b _) -> Pair a b; Cons a Nil -> Pair a 0; _ -> Pair 0 0};
+fromJusts p = case p of {Pair (Just a) (Just b) -> a + b; Pair (Just a) _ -> a; Pair _ b -> 100}`)
+	expect.EQ(t, run(t, km, `fromMaybe 10 Nothing`).String(), "10")
+	expect.EQ(t, run(t, km, `fromMaybe 10 (Just 20)`).String(), "20")
+	expect.EQ(t, run(t, km, `sum (Cons 1 (Cons 2 (Cons 3 Nil)))`).String(), "6")
+	expect.EQ(t, run(t, km, `isZero 0`).String(), "true")
+	expect.EQ(t, run(t, km, `isZero 1`).String(), "false")
+	expect.EQ(t, run(t, km, `firstTwo (Cons 1 (Cons 2 (Cons 3 Nil)))`).String(), "Pair 1 2")
+	expect.EQ(t, run(t, km, `firstTwo (Cons 1 Nil)`).String(), "Pair 1 0")
+	expect.EQ(t, run(t, km, `firstTwo Nil`).String(), "Pair 0 0")
+	expect.EQ(t, run(t, km, `fromJusts (Pair (Just 1) (Just 2))`).String(), "3")
+	expect.EQ(t, run(t, km, `fromJusts (Pair (Just 1) Nothing)`).String(), "1")
+	expect.EQ(t, run(t, km, `fromJusts (Pair Nothing Nothing)`).String(), "100")
+	// The alternatives that aren't selected are not evaluated.
+	expect.EQ(t, run(t, km, `loop x = loop x; case Just 1 of {Just x -> x; Nothing -> loop 1}`).String(), "1")
+	// The scrutinee is not evaluated beyond what the patterns need.
+	expect.EQ(t, run(t, km, `case Cons 1 (loop 1) of {Cons x _ -> x}`).String(), "1")
+
+	_, err := km.RunErr(km.Compile(minifp.Parse(strings.NewReader(`case Just 1 of {Nothing -> 0}`))[0]))
+	var runErr *minifp.RuntimeError
+	expect.True(t, errors.As(err, &runErr))
+	expect.HasSubstr(t, err.Error(), "non-exhaustive patterns")
+	expect.EQ(t, runErr.Pos.String(), "<input>:1:1")
+}
+
+func TestDataSharing(t *testing.T) {
+	km := minifp.NewMachine()
+	run(t, km, listDecls+`fst p = case p of {Pair a _ -> a}; snd p = case p of {Pair _ b -> b}`)
+	// The field is evaluated once, even though it is read twice. Evaluating
+	// "1+2" takes 8 more steps than evaluating "3".
+	var steps1, steps2 int
+	km.SetTracer(stepCounter{&steps1})
+	run(t, km, `letrec p = Pair (1+2) 0 in fst p + fst p`)
+	km.SetTracer(stepCounter{&steps2})
+	run(t, km, `letrec p = Pair 3 0 in fst p + fst p`)
+	expect.EQ(t, steps1-steps2, 8)
+}
+
+func TestDataMaxForce(t *testing.T) {
+	km := minifp.NewMachine(minifp.MaxForce(3))
+	run(t, km, listDecls+`from n = Cons n (from (n+1))`)
+	expect.EQ(t, run(t, km, `from 1`).String(), "Cons 1 (Cons 2 (Cons 3 ...))")
+	// The values are forced breadth-first.
+	expect.EQ(t, run(t, km, `Pair (from 1) (Just 2)`).String(), "Pair (Cons 1 ...) (Just 2)")
+	expect.EQ(t, run(t, km, `Cons 1 (Cons 2 Nil)`).String(), "Cons 1 (Cons 2 Nil)")
+}
+
+type stepCounter struct{ n *int }
+
+func (c stepCounter) OnStep(int, minifp.KCode, minifp.TraceEnv, minifp.TraceStack) { *c.n++ }
+func (stepCounter) OnReturn(int, minifp.Literal)                                   {}
+func (stepCounter) OnUpdate(int, minifp.Symbol, minifp.KClosure)                   {}
+
+func TestDataTypes(t *testing.T) {
+	for _, test := range []struct{ src, want string }{
+		{listDecls + `Just`, "a -> Maybe a"},
+		{listDecls + `Pair 1`, "a -> Pair Int a"},
+		{listDecls + `\m -> case m of {Nothing -> 0; Just x -> x}`, "Maybe Int -> Int"},
+		{listDecls + `\p -> case p of {Pair (Just a) b -> Just b; _ -> Nothing}`, "Pair (Maybe a) b -> Maybe b"},
+		{listDecls + `sum xs = case xs of {Nil -> 0; Cons x rest -> x + sum rest}; sum`, "IntList -> Int"},
+		{`data List a = Nil | Cons a (List a); map f xs = case xs of {Nil -> Nil; Cons x r -> Cons (f x) (map f r)}; map`,
+			"(a -> b) -> List a -> List b"},
+		{`data Fn a = Fn (a -> a); \f -> case f of {Fn g -> g 1}`, "Fn Int -> Int"},
+	} {
+		got, err := typeOf(t, test.src)
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, got, test.want, test.src)
+	}
+	for _, test := range []struct{ src, want string }{
+		{listDecls + `case Just 1 of {Nothing -> 0; Just x -> x == 1}`, "type mismatch: expect Int, but found Bool"},
+		{listDecls + `case 1 of {Nothing -> 0}`, "type mismatch: expect Int, but found Maybe a"},
+		{listDecls + `case Nothing of {Just x y -> 0}`, "constructor Just is applied to too many arguments"},
+		{`data T = T Foo`, "type Foo not found"},
+		{`data T = T a`, "type variable a not found"},
+		{`data T a = T (T a a)`, "type T takes 1 arguments, but found 2"},
+		{`Foo 1`, "constructor Foo not found"},
+	} {
+		_, err := typeOf(t, test.src)
+		expect.True(t, err != nil, test.src)
+		if err != nil {
+			expect.HasSubstr(t, err.Error(), test.want, test.src)
+		}
+	}
+}
+
+func TestDataParse(t *testing.T) {
+	nodes := minifp.Parse(strings.NewReader(`data Tree a = Leaf | Node (Tree a) a (Tree a);
+case t of {Node Leaf x _ -> x; Node (Node _ y _) 10 _ -> y; _ -> 0}`))
+	expect.EQ(t, nodes[0].String(), "data Tree a = Leaf | Node (Tree a) a (Tree a)")
+	expect.EQ(t, nodes[1].String(), "case t of {(Node Leaf x _) -> x; (Node (Node _ y _) 10 _) -> y; _ -> 0}")
+}
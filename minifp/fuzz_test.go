@@ -0,0 +1,191 @@
+package minifp_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"text/scanner"
+
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+// fuzzSeeds are programs taken from the other tests. They exercise every part
+// of the syntax, and some of them fail to parse or to run.
+var fuzzSeeds = []string{
+	`1 + 2 * 3`,
+	`(\x y -> x - y) 10 3`,
+	`-5 % 3; div (-7) 2; mod 7 (-2)`,
+	`99999999999999999999 * 99999999999999999999`,
+	`1.5 * 2.0`,
+	`"hello" ++ " " ++ show (1 + 2)`,
+	`strlen "abc"; 'x'`,
+	`letrec fact n = if (n == 0) 1 (n * fact (n - 1)) in fact 20`,
+	`letrec even n = if (n == 0) true (odd (n - 1)); odd n = if (n == 0) false (even (n - 1)) in even 10`,
+	`letrec xs = 1 : 2 : xs in take 5 xs`,
+	`letrec x = x + 1 in x`,
+	`letrec f x = f (x + 1) in f 0`,
+	`1 < 2 && (1 / 0 == 0) || true`,
+	`sq x = x * x; sq (sq 3)`,
+	`[1, 2, 3]; [1, 1 == 1]; 1 : 2`,
+	`map (\x -> x * 2) (filter (\x -> x % 2 == 0) (range 0 10))`,
+	`sum (take 10 (iterate (\x -> x + 1) 0))`,
+	`case [1, 2, 3] of {x : (y : _) -> x + y; _ -> 0}`,
+	`case xs of {[] -> 0; x : _ : rest -> x; [a, b] -> b}`,
+	`\xs -> case xs of {[x] -> x; 1 -> 1}`,
+	`case -1 of {-1 -> "neg"; _ -> "other"}`,
+	`data Tree a = Leaf | Node (Tree a) a (Tree a); Node Leaf 1 (Node Leaf 2 Leaf)`,
+	`data Shape = Circle Int | Rect Int Int; case Rect 2 3 of {Circle r -> r; Rect w h -> w * h}`,
+	`map (\p -> case p of {Pair a b -> a + b}) (zip [1, 2] [10, 20])`,
+	`(\x -> letrec x = 10 in x) 1`,
+	`module Foo; import Bar; import Baz (x, y); import Qux (); Bar.f x`,
+	`fromChars (toChars "hello")`,
+	`1 +`,
+	`letrec in 1`,
+	`\ -> 1`,
+	`-"a"`,
+	`(1 2`,
+	`case x of {}`,
+	`1 = 2; x`,
+	`a A.a0=0`,
+}
+
+func addFuzzSeeds(f *testing.F) {
+	for _, src := range fuzzSeeds {
+		f.Add(src)
+	}
+	prelude, err := os.ReadFile("prelude.mfp")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(string(prelude))
+}
+
+// sameAST reports whether two values are equal, ignoring source positions.
+func sameAST(a, b reflect.Value) bool {
+	if a.Kind() != b.Kind() || a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Kind() == reflect.Ptr && a.Pointer() == b.Pointer() {
+			return true
+		}
+		return sameAST(a.Elem(), b.Elem())
+	case reflect.Struct:
+		if a.Type() == reflect.TypeOf(scanner.Position{}) {
+			return true
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if !sameAST(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !sameAST(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+		iter := a.MapRange()
+		for iter.Next() {
+			v := b.MapIndex(iter.Key())
+			if !v.IsValid() || !sameAST(iter.Value(), v) {
+				return false
+			}
+		}
+		return true
+	case reflect.Bool:
+		return a.Bool() == b.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() == b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() == b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() == b.Float()
+	case reflect.String:
+		return a.String() == b.String()
+	case reflect.Func:
+		return a.Pointer() == b.Pointer()
+	}
+	panic(fmt.Sprintf("sameAST: unexpected %v", a.Type()))
+}
+
+// FuzzParse checks that the parser reports errors instead of panicking, and
+// that the String of a parsed node parses back to the same tree, except for
+// source positions.
+func FuzzParse(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		nodes, err := minifp.ParseErr(strings.NewReader(src))
+		if err != nil {
+			var errs minifp.ErrorList
+			if !errors.As(err, &errs) || len(errs) == 0 {
+				t.Fatalf("%q: got %v, want an ErrorList", src, err)
+			}
+			return
+		}
+		for _, node := range nodes {
+			str := node.String()
+			reparsed, err := minifp.ParseErr(strings.NewReader(str))
+			if err != nil {
+				t.Fatalf("%q: parse %q: %v", src, str, err)
+			}
+			if len(reparsed) != 1 || !sameAST(reflect.ValueOf(node), reflect.ValueOf(reparsed[0])) {
+				t.Fatalf("%q: %q parses to %v", src, str, reparsed)
+			}
+		}
+	})
+}
+
+// FuzzCompileRun checks that the machine reports errors, including type errors
+// and nontermination, as a *RuntimeError, and that it stops after MaxSteps.
+func FuzzCompileRun(f *testing.F) {
+	const maxSteps = 2000
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, src string) {
+		nodes, err := minifp.ParseErr(strings.NewReader(src))
+		if err != nil {
+			return
+		}
+		var steps int
+		km := minifp.NewMachine(minifp.MaxSteps(maxSteps), minifp.WithTracer(stepCounter{&steps}))
+		for _, node := range nodes {
+			code, err := km.CompileErr(node)
+			if err != nil {
+				continue
+			}
+			steps = 0
+			_, err = km.RunErr(code)
+			if steps > maxSteps {
+				t.Fatalf("%q: %v took %d steps", src, node, steps)
+			}
+			if err == nil {
+				continue
+			}
+			var runErr *minifp.RuntimeError
+			if !errors.As(err, &runErr) {
+				t.Fatalf("%q: got %v, want a RuntimeError", src, err)
+			}
+			var goErr runtime.Error
+			if errors.As(err, &goErr) {
+				t.Fatalf("%q: %v", src, err)
+			}
+		}
+	})
+}
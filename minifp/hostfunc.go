@@ -0,0 +1,230 @@
+package minifp
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Value is a minifp value, as passed to and returned by the functions
+// registered by RegisterFunc.
+type Value = Literal
+
+// RegisterFunc makes a Go function callable from code compiled by this machine
+// afterwards. The function takes nArgs arguments, each evaluated to a value
+// before the call. A function of no argument is called each time it is
+// referenced. If fn returns an error, the evaluation fails with a
+// *RuntimeError that wraps it. The function is visible only in this machine; a
+// global of the same name takes precedence over it, and it takes precedence over
+// a builtin of the same name.
+//
+// The type checker treats the function as polymorphic in its arguments and
+// result, so it can't check how the result is used. Use RegisterFuncSig or
+// RegisterGoFunc to register a function with a precise type.
+func (k *KMachine) RegisterFunc(name string, nArgs int, fn func(args ...Value) (Value, error)) error {
+	if nArgs < 0 {
+		return fmt.Errorf("register %s: invalid number of arguments %d", name, nArgs)
+	}
+	var sig []string
+	for i := 0; i <= nArgs; i++ {
+		sig = append(sig, string(rune('a'+i)))
+	}
+	return k.registerFunc(name, strings.Join(sig, " -> "), fn)
+}
+
+// RegisterFuncSig is similar to RegisterFunc, but it takes the type of the
+// function, e.g., "Int -> [a] -> a", instead of the number of arguments. The
+// function takes as many arguments as there are arrows in sig. Lowercase names
+// are type variables; "num" can be bound only to Int or Float, and "ord" only to
+// a type whose values can be compared. The type is trusted by the type checker,
+// so fn must return a value of the result type.
+func (k *KMachine) RegisterFuncSig(name string, sig string, fn func(args ...Value) (Value, error)) error {
+	return k.registerFunc(name, sig, fn)
+}
+
+// RegisterGoFunc is similar to RegisterFunc, but it takes an arbitrary Go
+// function, such as func(int64, string) (bool, error). Its parameters and result
+// are converted between Go and minifp values as follows:
+//
+//	int, int64, *big.Int <-> Int
+//	float64              <-> Float
+//	bool                 <-> Bool
+//	string               <-> String
+//	rune                 <-> Char
+//	Value                <-> any type
+//
+// The type of the function is derived from the Go types. A Value parameter
+// accepts any type, but the result can't be a Value, because its type would be
+// unknown; use RegisterFuncSig instead. The function may return an error as the
+// second result.
+func (k *KMachine) RegisterGoFunc(name string, fn interface{}) error {
+	fv := reflect.ValueOf(fn)
+	ft := fv.Type()
+	if ft.Kind() != reflect.Func {
+		return fmt.Errorf("register %s: expect a function, but found %v", name, ft)
+	}
+	if ft.IsVariadic() {
+		return fmt.Errorf("register %s: unsupported function type %v", name, ft)
+	}
+	if ft.NumOut() < 1 || ft.NumOut() > 2 || (ft.NumOut() == 2 && ft.Out(1) != errorType) {
+		return fmt.Errorf("register %s: function %v must return a value, optionally followed by an error", name, ft)
+	}
+	var (
+		sig      []string
+		nTypeVar int
+	)
+	typeName := func(t reflect.Type) (string, error) {
+		name, ok := goTypeNames[t]
+		if !ok {
+			return "", fmt.Errorf("register: unsupported type %v", t)
+		}
+		if name == "" {
+			name = string(rune('a' + nTypeVar))
+			nTypeVar++
+		}
+		return name, nil
+	}
+	for i := 0; i < ft.NumIn(); i++ {
+		t, err := typeName(ft.In(i))
+		if err != nil {
+			return err
+		}
+		sig = append(sig, t)
+	}
+	if ft.Out(0) == literalType {
+		return fmt.Errorf("register %s: the result type of %v is unknown; use RegisterFuncSig", name, ft)
+	}
+	t, err := typeName(ft.Out(0))
+	if err != nil {
+		return err
+	}
+	sig = append(sig, t)
+	return k.registerFunc(name, strings.Join(sig, " -> "), func(args ...Literal) (Literal, error) {
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			in[i] = fromLiteral(arg, ft.In(i))
+		}
+		out := fv.Call(in)
+		if len(out) == 2 && !out[1].IsNil() {
+			return Literal{}, out[1].Interface().(error)
+		}
+		return toLiteral(out[0]), nil
+	})
+}
+
+func (k *KMachine) registerFunc(name string, sig string, fn func(args ...Literal) (Literal, error)) error {
+	if !isIdent(name) {
+		return fmt.Errorf("register %s: invalid function name", name)
+	}
+	sigType, err := parseTypeSigErr(sig)
+	if err != nil {
+		return fmt.Errorf("register %s: %v", name, err)
+	}
+	nArgs := 0
+	for t, ok := sigType.(*TypeCon); ok && t.Name == "->"; t, ok = t.Args[1].(*TypeCon) {
+		nArgs++
+	}
+	k.funcs[name] = &funcSpec{
+		name:    name,
+		nArg:    nArgs,
+		sig:     sig,
+		sigType: sigType,
+		cb: func(args ...Literal) Literal {
+			val, err := fn(args...)
+			if err != nil {
+				panic(err)
+			}
+			return val
+		},
+	}
+	return nil
+}
+
+// isIdent checks if name is a valid variable name.
+func isIdent(name string) bool {
+	for i, ch := range name {
+		if !(unicode.IsLetter(ch) || ch == '_' || (i > 0 && unicode.IsDigit(ch))) {
+			return false
+		}
+		if i == 0 && unicode.IsUpper(ch) {
+			return false
+		}
+	}
+	return name != "" && !keywords[name]
+}
+
+var keywords = map[string]bool{
+	"letrec": true, "in": true, "if": true, "data": true, "case": true, "of": true,
+	"module": true, "import": true,
+}
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	literalType = reflect.TypeOf(Literal{})
+	bigIntType  = reflect.TypeOf((*big.Int)(nil))
+)
+
+// goTypeNames maps the Go types supported by RegisterGoFunc to minifp type
+// names. An empty name means a fresh type variable.
+var goTypeNames = map[reflect.Type]string{
+	reflect.TypeOf(int(0)):     "Int",
+	reflect.TypeOf(int64(0)):   "Int",
+	bigIntType:                 "Int",
+	reflect.TypeOf(float64(0)): "Float",
+	reflect.TypeOf(false):      "Bool",
+	reflect.TypeOf(""):         "String",
+	reflect.TypeOf(rune(0)):    "Char",
+	literalType:                "",
+}
+
+// fromLiteral converts val to a Go value of type t, which must be in
+// goTypeNames.
+func fromLiteral(val Literal, t reflect.Type) reflect.Value {
+	switch t {
+	case literalType:
+		return reflect.ValueOf(val)
+	case bigIntType:
+		return reflect.ValueOf(new(big.Int).Set(val.Big()))
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		return reflect.ValueOf(val.Int()).Convert(t)
+	case reflect.Int32:
+		return reflect.ValueOf(val.Char())
+	case reflect.Float64:
+		return reflect.ValueOf(val.Float())
+	case reflect.Bool:
+		return reflect.ValueOf(val.Bool())
+	case reflect.String:
+		return reflect.ValueOf(val.Str())
+	}
+	panic(t)
+}
+
+// toLiteral converts a Go value whose type is in goTypeNames to a Literal.
+func toLiteral(v reflect.Value) Literal {
+	switch v.Type() {
+	case literalType:
+		return v.Interface().(Literal)
+	case bigIntType:
+		return newLiteralBig(new(big.Int).Set(v.Interface().(*big.Int)))
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		return NewLiteralInt(v.Int())
+	case reflect.Int32:
+		return NewLiteralChar(rune(v.Int()))
+	case reflect.Float64:
+		return NewLiteralFloat(v.Float())
+	case reflect.Bool:
+		if v.Bool() {
+			return kTrue
+		}
+		return kFalse
+	case reflect.String:
+		return NewLiteralString(v.String())
+	}
+	panic(v)
+}
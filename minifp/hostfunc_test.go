@@ -0,0 +1,147 @@
+package minifp_test
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+func TestRegisterFunc(t *testing.T) {
+	km := minifp.NewMachine()
+	var calls int
+	expect.NoError(t, km.RegisterFunc("inc", 1, func(args ...minifp.Value) (minifp.Value, error) {
+		calls++
+		return minifp.NewLiteralInt(args[0].Int() + 1), nil
+	}))
+	expect.NoError(t, km.RegisterFunc("pick", 2, func(args ...minifp.Value) (minifp.Value, error) {
+		if args[0].Bool() {
+			return args[1], nil
+		}
+		return minifp.NewLiteralString("none"), nil
+	}))
+	expect.EQ(t, run(t, km, `inc 10`).String(), "11")
+	expect.EQ(t, run(t, km, `map inc [1, 2]`).String(), "[2, 3]")
+	expect.EQ(t, run(t, km, `pick (1 == 1) (inc 1)`).String(), "2")
+	expect.EQ(t, run(t, km, `pick (1 == 2) 0`).String(), `"none"`)
+	expect.EQ(t, calls, 4)
+
+	// The functions are visible only in the machine they are registered on.
+	_, err := typeOf(t, `inc 1`)
+	expect.HasSubstr(t, err.Error(), "variable inc not found")
+
+	// A global shadows a registered function.
+	expect.EQ(t, run(t, km, `inc x = x + 100; inc 1`).String(), "101")
+
+	expect.HasSubstr(t, km.RegisterFunc("Foo", 1, nil).Error(), "invalid function name")
+	expect.HasSubstr(t, km.RegisterFunc("letrec", 1, nil).Error(), "invalid function name")
+	expect.HasSubstr(t, km.RegisterFunc("f", -1, nil).Error(), "invalid number of arguments -1")
+}
+
+func TestRegisterFuncSig(t *testing.T) {
+	km := minifp.NewMachine()
+	expect.NoError(t, km.RegisterFuncSig("inc", "Int -> Int", func(args ...minifp.Value) (minifp.Value, error) {
+		return minifp.NewLiteralInt(args[0].Int() + 1), nil
+	}))
+	expect.NoError(t, km.RegisterFuncSig("first", "a -> b -> a", func(args ...minifp.Value) (minifp.Value, error) {
+		return args[0], nil
+	}))
+	expect.NoError(t, km.RegisterFuncSig("answer", "Int", func(args ...minifp.Value) (minifp.Value, error) {
+		return minifp.NewLiteralInt(42), nil
+	}))
+	expect.EQ(t, run(t, km, `map inc [1, 2]`).String(), "[2, 3]")
+	expect.EQ(t, run(t, km, `first "a" 1`).String(), `"a"`)
+	expect.EQ(t, run(t, km, `answer + 1`).String(), "43")
+
+	expect.HasSubstr(t, km.RegisterFuncSig("Foo", "Int -> Int", nil).Error(), "invalid function name")
+	expect.HasSubstr(t, km.RegisterFuncSig("f", "Int ->", nil).Error(), `invalid type signature "Int ->"`)
+	expect.HasSubstr(t, km.RegisterFuncSig("f", "(Int", nil).Error(), "invalid type signature")
+}
+
+func TestRegisterFuncError(t *testing.T) {
+	km := minifp.NewMachine()
+	errDenied := errors.New("permission denied")
+	expect.NoError(t, km.RegisterFunc("readFile", 1, func(args ...minifp.Value) (minifp.Value, error) {
+		return minifp.Value{}, fmt.Errorf("read %s: %w", args[0].Str(), errDenied)
+	}))
+	x := minifp.Parse(strings.NewReader(`strlen (readFile "/etc/passwd")`))
+	_, err := km.RunErr(km.Compile(x[0]))
+	expect.True(t, errors.Is(err, errDenied), err)
+	var runErr *minifp.RuntimeError
+	expect.True(t, errors.As(err, &runErr))
+	expect.EQ(t, runErr.Code.DebugString(), "readFile")
+	expect.EQ(t, runErr.Msg, "read /etc/passwd: permission denied")
+
+	// The machine remains usable.
+	expect.EQ(t, run(t, km, `1 + 2`).String(), "3")
+}
+
+func TestRegisterGoFunc(t *testing.T) {
+	km := minifp.NewMachine()
+	expect.NoError(t, km.RegisterGoFunc("repeat", strings.Repeat))
+	expect.NoError(t, km.RegisterGoFunc("half", func(x float64) float64 { return x / 2 }))
+	expect.NoError(t, km.RegisterGoFunc("isUpper", func(r rune) bool { return r >= 'A' && r <= 'Z' }))
+	expect.NoError(t, km.RegisterGoFunc("square", func(x *big.Int) *big.Int { return new(big.Int).Mul(x, x) }))
+	expect.NoError(t, km.RegisterGoFunc("typeName", func(v minifp.Value) string { return fmt.Sprintf("%T", v) }))
+	expect.NoError(t, km.RegisterGoFunc("checkedDiv", func(x, y int64) (int64, error) {
+		if y == 0 {
+			return 0, errors.New("checkedDiv: division by zero")
+		}
+		return x / y, nil
+	}))
+	for _, test := range []struct{ src, want string }{
+		{`repeat "ab" 3`, `"ababab"`},
+		{`half 3`, "1.5"},
+		{`filter isUpper (toChars "aBcD")`, `['B', 'D']`},
+		{`square 99999999999`, "9999999999800000000001"},
+		{`typeName 1`, `"minifp.Literal"`},
+		{`checkedDiv 10 3`, "3"},
+	} {
+		expect.EQ(t, run(t, km, test.src).String(), test.want, test.src)
+	}
+	x := minifp.Parse(strings.NewReader(`checkedDiv 1 0`))
+	_, err := km.RunErr(km.Compile(x[0]))
+	expect.HasSubstr(t, err.Error(), "checkedDiv: division by zero")
+
+	expect.HasSubstr(t, km.RegisterGoFunc("f", 10).Error(), "expect a function")
+	expect.HasSubstr(t, km.RegisterGoFunc("f", func(x []int) int { return 0 }).Error(), "unsupported type []int")
+	expect.HasSubstr(t, km.RegisterGoFunc("f", func(x int) {}).Error(), "must return a value")
+	expect.HasSubstr(t, km.RegisterGoFunc("f", func(x ...int) int { return 0 }).Error(), "unsupported function type")
+	expect.HasSubstr(t, km.RegisterGoFunc("f", func(v minifp.Value) minifp.Value { return v }).Error(), "use RegisterFuncSig")
+}
+
+func TestRegisterFuncTypes(t *testing.T) {
+	km := minifp.NewMachine()
+	expect.NoError(t, km.RegisterGoFunc("repeat", strings.Repeat))
+	expect.NoError(t, km.RegisterGoFunc("half", func(x float64) float64 { return x / 2 }))
+	expect.NoError(t, km.RegisterGoFunc("describe", func(v minifp.Value) string { return v.String() }))
+	expect.NoError(t, km.RegisterFunc("untyped", 2, func(args ...minifp.Value) (minifp.Value, error) { return args[0], nil }))
+	expect.NoError(t, km.RegisterFuncSig("first", "a -> b -> a", func(args ...minifp.Value) (minifp.Value, error) { return args[0], nil }))
+	expect.NoError(t, km.RegisterFuncSig("largest", "[ord] -> ord", func(args ...minifp.Value) (minifp.Value, error) { return args[0], nil }))
+	tc := minifp.NewTypeChecker()
+	tc.DeclareFuncs(km)
+	for _, test := range []struct{ src, want string }{
+		{`repeat`, "String -> Int -> String"},
+		{`half 1`, "Float"},
+		{`describe`, "a -> String"},
+		{`untyped`, "a -> b -> c"},
+		{`first`, "a -> b -> a"},
+		{`first 1 "a" + 2`, "Int"},
+	} {
+		typ, err := tc.Check(minifp.Parse(strings.NewReader(test.src))[0])
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, typ.String(), test.want, test.src)
+	}
+	for _, test := range []struct{ src, want string }{
+		{`repeat 1 "a"`, "type mismatch: expect String, but found Int"},
+		{`first "a" 1 + 2`, "type mismatch: expect Int, but found String"},
+		{`largest [[1]]`, "type [Int] cannot be compared"},
+	} {
+		_, err := tc.Check(minifp.Parse(strings.NewReader(test.src))[0])
+		expect.HasSubstr(t, err.Error(), test.want, test.src)
+	}
+}
@@ -1,13 +1,19 @@
 package minifp
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"math/big"
+	"strconv"
 	"strings"
 	"text/scanner"
 )
 
 type KCode interface {
+	// Pos returns the source-code location of the expression that this code was
+	// compiled from.
+	Pos() scanner.Position
 	DebugString() string
 }
 
@@ -18,11 +24,26 @@ const (
 	LiteralInt
 	LiteralBool
 	LiteralNil
+	LiteralString
+	LiteralFloat
+	// LiteralBigInt is an integer that doesn't fit in int64. Its type is Int.
+	LiteralBigInt
+	// LiteralChar is a Unicode code point. It is stored in intVal.
+	LiteralChar
+	// LiteralCon is a data constructor value.
+	LiteralCon
+	// LiteralFunc is returned by RunContext when the result is a function. Its
+	// contents are opaque.
+	LiteralFunc
 )
 
 type Literal struct {
-	typ    LiteralType
-	intVal int64
+	typ      LiteralType
+	intVal   int64
+	floatVal float64
+	strVal   string
+	bigVal   *big.Int
+	con      *conValue
 }
 
 var (
@@ -31,7 +52,13 @@ var (
 	kFalse = Literal{typ: LiteralBool, intVal: 0}
 )
 
-func NewLiteralInt(v int64) Literal { return Literal{typ: LiteralInt, intVal: int64(v)} }
+func NewLiteralInt(v int64) Literal     { return Literal{typ: LiteralInt, intVal: int64(v)} }
+func NewLiteralFloat(v float64) Literal { return Literal{typ: LiteralFloat, floatVal: v} }
+func NewLiteralString(v string) Literal { return Literal{typ: LiteralString, strVal: v} }
+func NewLiteralChar(v rune) Literal     { return Literal{typ: LiteralChar, intVal: int64(v)} }
+
+// Type returns the kind of the value.
+func (l Literal) Type() LiteralType { return l.typ }
 
 func (l Literal) String() string {
 	switch l.typ {
@@ -42,51 +69,122 @@ func (l Literal) String() string {
 			return "false"
 		}
 		return "true"
+	case LiteralNil:
+		return "nil"
+	case LiteralFloat:
+		s := strconv.FormatFloat(l.floatVal, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eIN") {
+			// Distinguish 2.0 from 2.
+			s += ".0"
+		}
+		return s
+	case LiteralBigInt:
+		return l.bigVal.String()
+	case LiteralString:
+		return strconv.Quote(l.strVal)
+	case LiteralChar:
+		return strconv.QuoteRune(rune(l.intVal))
+	case LiteralCon:
+		return l.con.String()
+	case LiteralFunc:
+		return "<function>"
 	}
-	panic(l)
+	return "invalid"
 }
 
 func (l Literal) Bool() bool {
 	if l.typ != LiteralBool {
-		panic(l)
+		panic(fmt.Sprintf("expect a bool, but found %v", l))
 	}
 	return l.intVal != 0
 }
 
 func (l Literal) Int() int64 {
+	if l.typ == LiteralBigInt {
+		panic(fmt.Sprintf("integer %v is out of range", l))
+	}
 	if l.typ != LiteralInt {
-		panic(l)
+		panic(fmt.Sprintf("expect an int, but found %v", l))
 	}
 	return l.intVal
 }
 
+// Float returns the value of a float. An int is converted to a float.
+func (l Literal) Float() float64 {
+	switch l.typ {
+	case LiteralFloat:
+		return l.floatVal
+	case LiteralInt:
+		return float64(l.intVal)
+	case LiteralBigInt:
+		f, _ := new(big.Float).SetInt(l.bigVal).Float64()
+		return f
+	}
+	panic(fmt.Sprintf("expect a float, but found %v", l))
+}
+
+func (l Literal) Str() string {
+	if l.typ != LiteralString {
+		panic(fmt.Sprintf("expect a string, but found %v", l))
+	}
+	return l.strVal
+}
+
+func (l Literal) Char() rune {
+	if l.typ != LiteralChar {
+		panic(fmt.Sprintf("expect a char, but found %v", l))
+	}
+	return rune(l.intVal)
+}
+
 type KLambda struct {
+	pos  scanner.Position
 	Arg  Symbol
 	Body KCode
+	// Captures lists the variables that Body refers to, other than Arg and the
+	// globals. The body runs in a frame that holds the argument, followed by
+	// these variables.
+	Captures []KAddr
 }
 
+func (k *KLambda) Pos() scanner.Position { return k.pos }
 func (k *KLambda) DebugString() string {
 	return "ƛ"
 }
 
 type KLetrec struct {
+	pos      scanner.Position
 	VarNames []Symbol
 	VarExprs []KCode
+	// Captures[i] lists the variables that VarExprs[i] refers to. They are read
+	// from the frame of the new variables.
+	Captures [][]KAddr
 	Body     KCode
 }
 
+func (k *KLetrec) Pos() scanner.Position { return k.pos }
 func (k *KLetrec) DebugString() string {
 	return fmt.Sprintf("letrec %v %v %v", k.VarNames, k.VarExprs, k.Body.DebugString())
 }
 
-type KApply struct{ Head, Tail KCode }
+type KApply struct {
+	pos        scanner.Position
+	Head, Tail KCode
+	// Captures lists the variables that Tail refers to.
+	Captures []KAddr
+}
 
+func (k *KApply) Pos() scanner.Position { return k.pos }
 func (k *KApply) DebugString() string {
 	return fmt.Sprintf("(%s %s)", k.Head.DebugString(), k.Tail.DebugString())
 }
 
-type KVar struct{ Addr KAddr }
+type KVar struct {
+	pos  scanner.Position
+	Addr KAddr
+}
 
+func (k *KVar) Pos() scanner.Position { return k.pos }
 func (k *KVar) DebugString() string {
 	return fmt.Sprintf("localvar:%v", k.Addr)
 }
@@ -95,39 +193,73 @@ type KRet struct{}
 
 var kRet = &KRet{}
 
+func (k *KRet) Pos() scanner.Position { return kUnknownPos }
 func (k *KRet) DebugString() string {
 	return "ret"
 }
 
-type KIf struct{}
-
-var kIf = &KIf{}
+type KIf struct{ pos scanner.Position }
 
+func (k *KIf) Pos() scanner.Position { return k.pos }
 func (k *KIf) DebugString() string {
 	return "if"
 }
 
-type KConst Literal
+type KConst struct {
+	pos scanner.Position
+	Val Literal
+}
 
+func (k *KConst) Pos() scanner.Position { return k.pos }
 func (k *KConst) DebugString() string {
-	return fmt.Sprintf("const:%+v", (*Literal)(k).String())
+	return fmt.Sprintf("const:%+v", k.Val.String())
 }
 
-type KApplyLeafFunction funcSpec
+type KApplyLeafFunction struct {
+	pos scanner.Position
+	Op  *funcSpec
+}
 
+func (k *KApplyLeafFunction) Pos() scanner.Position { return k.pos }
 func (k *KApplyLeafFunction) DebugString() string {
-	return k.name
+	return k.Op.name
 }
 
-type KSwapStack struct{ N int }
+// KSwapStack evaluates the next argument of a builtin. The stack holds, from the
+// top, the values of the N arguments evaluated so far, the closure of the next
+// argument, and the continuation. KSwapStack moves the values below the
+// continuation, and runs the closure.
+type KSwapStack struct {
+	pos scanner.Position
+	N   int
+}
 
+func (k *KSwapStack) Pos() scanner.Position { return k.pos }
 func (k *KSwapStack) DebugString() string {
 	return fmt.Sprintf("swapstack:%+v", k.N)
 }
 
+// KBlackHole replaces a thunk while the thunk is being forced. Entering it
+// means that the value of the variable depends on itself, e.g., "letrec x = x +
+// 1 in x", so evaluation fails with ErrLoop instead of running forever.
+type KBlackHole struct {
+	// pos is the location of the thunk's code.
+	pos scanner.Position
+	Sym Symbol
+}
+
+func (k *KBlackHole) Pos() scanner.Position { return k.pos }
+func (k *KBlackHole) DebugString() string {
+	return fmt.Sprintf("blackhole:%v", k.Sym)
+}
+
 type kStackEntry struct {
-	cl      KClosure
-	pointer *KClosure
+	// cl is the closure to run next. If pointer is non-nil, it is the thunk of
+	// the variable, saved so that it can be restored if evaluation fails.
+	cl KClosure
+	// pointer, if non-nil, marks the variable whose thunk is being forced. It is
+	// updated with the value once the thunk reaches the normal form.
+	pointer *kVarEntry
 }
 
 func (s kStackEntry) String() string {
@@ -144,9 +276,14 @@ type kVarEntry struct {
 	cl  KClosure
 }
 
+// kEnvFrame is a frame of local variables. The environment of a closure is a
+// single frame that holds only the variables that the closure refers to, so
+// that the closure doesn't keep the rest of the enclosing environment alive.
+// Letrec and case push frames on top of it. The variables are shared with the
+// environments that they are captured from, so a thunk is evaluated once.
 type kEnvFrame struct {
 	Const *Literal
-	vars  []kVarEntry
+	vars  []*kVarEntry
 	next  *kEnvFrame
 }
 
@@ -161,26 +298,109 @@ type kEnvFrame struct {
 // 	return cl, s2
 // }
 
-func NewMachine() *KMachine {
-	return &KMachine{}
+// Option configures a KMachine created by NewMachine.
+type Option func(k *KMachine)
+
+// MaxSteps limits the number of steps that a single RunContext call may take.
+// A builtin applied to big integers takes one extra step for each 64-bit word
+// of its arguments. Zero means no limit.
+func MaxSteps(n int) Option { return func(k *KMachine) { k.maxSteps = n } }
+
+// MaxStackDepth limits the size of the machine stack. Zero means no limit.
+func MaxStackDepth(n int) Option { return func(k *KMachine) { k.maxStackDepth = n } }
+
+// MaxEnvFrames limits the number of environment frames that a single
+// RunContext call may allocate. Zero means no limit.
+func MaxEnvFrames(n int) Option { return func(k *KMachine) { k.maxEnvFrames = n } }
+
+// MaxForce limits the number of constructor values that RunContext evaluates
+// when it forces the fields of the result. The fields of the values beyond the
+// limit are left unevaluated, and are rendered as "...", so that an infinite
+// data structure prints as a prefix of it. The values are forced breadth-first.
+// The limit doesn't apply to CallContext, which converts the whole result to
+// Go. Zero means no limit.
+func MaxForce(n int) Option { return func(k *KMachine) { k.maxForce = n } }
+
+var (
+	// ErrStepLimit is reported when evaluation exceeds MaxSteps.
+	ErrStepLimit = errors.New("step limit exceeded")
+	// ErrStackLimit is reported when evaluation exceeds MaxStackDepth.
+	ErrStackLimit = errors.New("stack depth limit exceeded")
+	// ErrEnvFrameLimit is reported when evaluation exceeds MaxEnvFrames.
+	ErrEnvFrameLimit = errors.New("env frame limit exceeded")
+	// ErrLoop is reported when the value of a variable depends on itself.
+	ErrLoop = errors.New("<<loop>>")
+)
+
+// NewMachine creates a machine. The functions defined in the prelude, such
+// as map and take, are available as globals unless NoPrelude is given. The
+// list and Pair constructors are always available.
+func NewMachine(opts ...Option) *KMachine {
+	k := newMachine(opts...)
+	if !k.noPrelude {
+		p := compiledPrelude()
+		k.Globals = append(k.Globals, p.Globals...)
+		for sym, spec := range p.cons {
+			k.cons[sym] = spec
+		}
+	}
+	return k
+}
+
+// newMachine creates a machine that knows only the builtin constructors.
+func newMachine(opts ...Option) *KMachine {
+	k := &KMachine{
+		cons:  map[Symbol]*conSpec{nilSpec.sym: nilSpec, consSpec.sym: consSpec, pairSpec.sym: pairSpec},
+		funcs: map[string]*funcSpec{},
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	return k
 }
 
 func (k *KMachine) Read(addr KAddr) *KClosure {
+	return &k.readVar(addr).cl
+}
+
+func (k *KMachine) readVar(addr KAddr) *kVarEntry {
+	return k.readVarIn(k.Locals, addr)
+}
+
+// readVarIn is similar to readVar, but it reads a local variable from env.
+func (k *KMachine) readVarIn(env *kEnvFrame, addr KAddr) *kVarEntry {
 	if addr.frameIndex == kGlobalFrame {
-		return &k.Globals[addr.varIndex].cl
+		if int(addr.varIndex) >= len(k.Globals) {
+			k.failf("global %v not found", addr)
+		}
+		return &k.Globals[addr.varIndex]
 	}
-	f := k.Locals
-	for addr.frameIndex > 0 {
+	f := env
+	for addr.frameIndex > 0 && f != nil {
 		f = f.next
 		addr.frameIndex--
 	}
 	if f == nil {
-		panic(k)
+		k.failf("frame for %v not found", addr)
+	}
+	if f.Const != nil || int(addr.varIndex) >= len(f.vars) {
+		k.failf("variable %v not found in %v", addr, f)
+	}
+	return f.vars[addr.varIndex]
+}
+
+// capture creates the environment of a closure. It has n empty slots for the
+// arguments, followed by the variables at addrs in env. It returns nil if the
+// environment is empty.
+func (k *KMachine) capture(env *kEnvFrame, n int, addrs []KAddr) *kEnvFrame {
+	if n+len(addrs) == 0 {
+		return nil
 	}
-	if f.Const != nil {
-		panic(f)
+	vars := make([]*kVarEntry, n+len(addrs))
+	for i, addr := range addrs {
+		vars[n+i] = k.readVarIn(env, addr)
 	}
-	return &f.vars[addr.varIndex].cl
+	return k.newFrame(kEnvFrame{vars: vars})
 }
 
 func (s *kEnvFrame) String() string {
@@ -194,8 +414,8 @@ func (s *kEnvFrame) String() string {
 		if i > 0 {
 			buf.WriteRune(' ')
 		}
-		if s.Const != nil {
-			buf.WriteString(fmt.Sprintf("const:%+v", *s.Const))
+		if f.Const != nil {
+			buf.WriteString(fmt.Sprintf("const:%+v", *f.Const))
 		} else {
 			buf.WriteRune('[')
 			for j, e := range f.vars {
@@ -243,6 +463,11 @@ func (cl KClosure) Literal() Literal {
 
 const kGlobalFrame = uint32(0xffffffff)
 
+// KAddr is the address of a variable. A local variable is addressed by a de
+// Bruijn index: frameIndex is the number of frames between the innermost frame
+// and the frame that binds the variable, and varIndex is its slot in that
+// frame. A global has frameIndex kGlobalFrame, and varIndex is its index in
+// KMachine.Globals.
 type KAddr struct {
 	frameIndex uint32
 	varIndex   uint32
@@ -254,22 +479,209 @@ type KMachine struct {
 	Locals  *kEnvFrame
 	Stack   []kStackEntry
 	step    int
+
+	maxSteps, maxStackDepth, maxEnvFrames, maxForce int
+	// nEnvFrames is the number of frames allocated by the current run.
+	nEnvFrames int
+	tracer     Tracer
+	overflow   OverflowMode
+	noPrelude  bool
+	// funcs holds the functions registered by RegisterFunc, RegisterFuncSig, and
+	// RegisterGoFunc.
+	funcs map[string]*funcSpec
+	// cons holds the data constructors declared so far.
+	cons map[Symbol]*conSpec
 }
 
+// RuntimeError is returned by RunErr when evaluation fails.
+type RuntimeError struct {
+	// Code is the instruction whose execution failed.
+	Code KCode
+	// Pos is the source location of Code.
+	Pos scanner.Position
+	// Step is the machine step count at the failure.
+	Step int
+	// Env and Stack are the rendered local environment and stack at the start of
+	// the failing step.
+	Env, Stack string
+	Msg        string
+	// Err is the underlying cause, if any, e.g., ErrStepLimit or
+	// context.Canceled.
+	Err error
+}
+
+func (e *RuntimeError) Error() string {
+	code := "<nil>"
+	if e.Code != nil {
+		code = e.Code.DebugString()
+	}
+	return fmt.Sprintf("%s: step %d: %s: %s", e.Pos, e.Step, code, e.Msg)
+}
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// failf aborts the current step with a *RuntimeError. Step checks its
+// preconditions before modifying the machine state, so the error describes the
+// state at the start of the step.
+func (k *KMachine) failf(format string, args ...interface{}) {
+	panic(k.newRuntimeError(fmt.Sprintf(format, args...)))
+}
+
+// failErr is similar to failf, but it records err as the cause.
+func (k *KMachine) failErr(err error) {
+	e := k.newRuntimeError(err.Error())
+	e.Err = err
+	panic(e)
+}
+
+// failLoop reports that the thunk replaced by b is entered again.
+func (k *KMachine) failLoop(b *KBlackHole) {
+	k.failErr(fmt.Errorf("%w: %v (defined at %v) depends on its own value", ErrLoop, b.Sym, b.pos))
+}
+
+func (k *KMachine) newRuntimeError(msg string) *RuntimeError {
+	e := &RuntimeError{
+		Code:  k.Code,
+		Step:  k.step,
+		Env:   k.Locals.String(),
+		Stack: fmt.Sprint(k.Stack),
+		Msg:   msg,
+	}
+	if k.Code != nil {
+		e.Pos = k.Code.Pos()
+	}
+	return e
+}
+
+// Run is similar to RunErr, but it panics on error.
 func (k *KMachine) Run(code KCode) Literal {
+	val, err := k.RunErr(code)
+	if err != nil {
+		panic(err)
+	}
+	return val
+}
+
+// RunErr is RunContext with a background context.
+func (k *KMachine) RunErr(code KCode) (Literal, error) {
+	return k.RunContext(context.Background(), code)
+}
+
+// RunContext evaluates the code and returns the resulting value. On error, it
+// returns a *RuntimeError. The globals remain usable after an error. Evaluation
+// is aborted when ctx is done or when it exceeds one of the limits set by the
+// machine options; the RuntimeError then wraps ctx.Err(), ErrStepLimit,
+// ErrStackLimit, or ErrEnvFrameLimit.
+func (k *KMachine) RunContext(ctx context.Context, code KCode) (val Literal, err error) {
+	return k.run(ctx, code, nil, k.maxForce)
+}
+
+// run evaluates the code, applied to args. The first argument is args[0]. At
+// most maxForce constructor values of the result are forced, or all of them if
+// maxForce is zero.
+func (k *KMachine) run(ctx context.Context, code KCode, args []KClosure, maxForce int) (val Literal, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			k.restoreThunks()
+			if e, ok := r.(*RuntimeError); ok {
+				err = e
+				return
+			}
+			e := k.newRuntimeError(fmt.Sprint(r))
+			e.Err, _ = r.(error)
+			err = e
+		}
+	}()
 	k.Code = code
-	for k.Step() {
+	k.Locals = nil
+	k.Stack = k.Stack[:0]
+	k.step = 0
+	k.nEnvFrames = 0
+	for i := len(args) - 1; i >= 0; i-- {
+		k.pushStack(kStackEntry{cl: args[i]})
+	}
+	k.eval(ctx)
+	switch k.Code.(type) {
+	case *KRet:
+	case *KLambda:
+		return Literal{typ: LiteralFunc}, nil
+	default:
+		k.failf("invalid instruction")
 	}
-	if k.Code != kRet {
-		panic(fmt.Sprintf("%d: %v %v %v", k.step, k.Code.DebugString(), k.Locals.String(), k.Stack))
+	val = *k.Locals.Const
+	k.force(ctx, val, maxForce)
+	return val, nil
+}
+
+// eval runs the machine until it halts.
+//
+// The context is checked after every step. A step that calls a builtin isn't
+// interrupted, so a cancellation that arrives during the call takes effect
+// once the builtin returns.
+func (k *KMachine) eval(ctx context.Context) {
+	done := ctx.Done()
+	for {
+		// The limit is checked before the step, since force calls eval again
+		// after the machine halts.
+		if k.maxSteps > 0 && k.step >= k.maxSteps {
+			k.failErr(ErrStepLimit)
+		}
+		if !k.Step() {
+			return
+		}
+		if done != nil {
+			select {
+			case <-done:
+				k.failErr(ctx.Err())
+			default:
+			}
+		}
 	}
-	return *k.Locals.Const
 }
 
 func (k *KMachine) pushStack(e kStackEntry) {
+	if k.maxStackDepth > 0 && len(k.Stack) >= k.maxStackDepth {
+		k.failErr(ErrStackLimit)
+	}
 	k.Stack = append(k.Stack, e)
 }
 
+// enter starts forcing the thunk stored in e. The thunk is replaced by a black
+// hole until it is updated with its value.
+func (k *KMachine) enter(e *kVarEntry) {
+	k.pushStack(kStackEntry{cl: e.cl, pointer: e})
+	k.Code = e.cl.Code
+	k.Locals = e.cl.Env
+	e.cl = KClosure{Code: &KBlackHole{pos: e.cl.Code.Pos(), Sym: e.sym}}
+}
+
+// restoreThunks puts back the thunks that were being forced when evaluation
+// failed, so that the variables can be forced again later.
+func (k *KMachine) restoreThunks() {
+	for _, s := range k.Stack {
+		if s.pointer != nil {
+			s.pointer.cl = s.cl
+		}
+	}
+}
+
+// update overwrites the thunk stored in e with its value.
+func (k *KMachine) update(e *kVarEntry, val KClosure) {
+	e.cl = val
+	if k.tracer != nil {
+		k.tracer.OnUpdate(k.step, e.sym, val)
+	}
+}
+
+// newFrame allocates a local environment frame.
+func (k *KMachine) newFrame(f kEnvFrame) *kEnvFrame {
+	k.nEnvFrames++
+	if k.maxEnvFrames > 0 && k.nEnvFrames > k.maxEnvFrames {
+		k.failErr(ErrEnvFrameLimit)
+	}
+	return &f
+}
+
 func (k *KMachine) popStack() kStackEntry {
 	n := len(k.Stack)
 	v := k.Stack[n-1]
@@ -277,43 +689,93 @@ func (k *KMachine) popStack() kStackEntry {
 	return v
 }
 
+// peekStack returns the i'th entry from the top of the stack.
+func (k *KMachine) peekStack(i int) kStackEntry {
+	n := len(k.Stack)
+	if i >= n {
+		k.failf("stack underflow: want %d entries, found %d", i+1, n)
+	}
+	return k.Stack[n-1-i]
+}
+
+// peekValue returns the value stored in the i'th entry from the top of the
+// stack.
+func (k *KMachine) peekValue(i int) Literal {
+	e := k.peekStack(i)
+	if e.pointer != nil || e.cl.Code != kRet || e.cl.Env.Const == nil {
+		k.failf("stack entry %d: expect a value, but found %v", i, e)
+	}
+	return *e.cl.Env.Const
+}
+
+// peekClosure returns the closure stored in the i'th entry from the top of the
+// stack.
+func (k *KMachine) peekClosure(i int) KClosure {
+	e := k.peekStack(i)
+	if e.pointer != nil {
+		k.failf("stack entry %d: expect a closure, but found an update marker", i)
+	}
+	return e.cl
+}
+
 func (k *KMachine) Step() bool {
 	k.step++
-	log.Printf("%d: %v %v %v", k.step, k.Code.DebugString(), k.Locals.String(), k.Stack)
+	if k.tracer != nil {
+		k.tracer.OnStep(k.step, k.Code, TraceEnv{k.Locals}, TraceStack(k.Stack))
+	}
 	switch v := k.Code.(type) {
 	case *KApply:
+		k.pushStack(kStackEntry{cl: KClosure{Code: v.Tail, Env: k.capture(k.Locals, 0, v.Captures)}})
 		k.Code = v.Head
-		k.Stack = append(k.Stack, kStackEntry{cl: KClosure{Code: v.Tail, Env: k.Locals}})
 	case *KVar:
-		cl := k.Read(v.Addr)
-		k.Code = cl.Code
-		k.Locals = cl.Env
-		k.pushStack(kStackEntry{pointer: cl})
+		e := k.readVar(v.Addr)
+		switch code := e.cl.Code.(type) {
+		case *KRet:
+			k.Code = code
+			k.Locals = e.cl.Env
+		case *KBlackHole:
+			k.failLoop(code)
+		default:
+			k.enter(e)
+		}
 	case *KLambda:
-		k.Code = v.Body
-		arg := k.popStack()
-		if arg.pointer != nil {
-			panic(arg)
+		// A lambda is in normal form, so it is the value of the thunks being
+		// forced.
+		for len(k.Stack) > 0 && k.Stack[len(k.Stack)-1].pointer != nil {
+			k.update(k.popStack().pointer, KClosure{Code: v, Env: k.Locals})
+		}
+		if len(k.Stack) == 0 {
+			// The result is a function.
+			return false
 		}
-		k.Locals = &kEnvFrame{
-			vars: []kVarEntry{{sym: v.Arg, cl: arg.cl}},
-			next: k.Locals}
+		arg := k.peekClosure(0)
+		frame := k.capture(k.Locals, 1, v.Captures)
+		frame.vars[0] = &kVarEntry{sym: v.Arg, cl: arg}
+		k.popStack()
+		k.Code = v.Body
+		k.Locals = frame
 	case *KLetrec:
-		frame := &kEnvFrame{vars: make([]kVarEntry, len(v.VarExprs)), next: k.Locals}
+		// The variables are allocated separately, so that a closure that captures
+		// one of them doesn't keep the others alive.
+		frame := k.newFrame(kEnvFrame{vars: make([]*kVarEntry, len(v.VarExprs)), next: k.Locals})
+		for i := range frame.vars {
+			frame.vars[i] = &kVarEntry{sym: v.VarNames[i]}
+		}
 		for i, b := range v.VarExprs {
-			frame.vars[i] = kVarEntry{
-				sym: v.VarNames[i],
-				cl:  KClosure{Code: b, Env: frame},
-			}
+			frame.vars[i].cl = KClosure{Code: b, Env: k.capture(frame, 0, v.Captures[i])}
 		}
 		k.Code = v.Body
 		k.Locals = frame
 	case *KConst:
+		frame := k.newFrame(kEnvFrame{Const: &v.Val})
 		k.Code = kRet
-		k.Locals = &kEnvFrame{Const: (*Literal)(v)}
+		k.Locals = frame
 	case *KRet:
-		if k.Locals.Const == nil {
-			panic(k)
+		if k.Locals == nil || k.Locals.Const == nil {
+			k.failf("no value to return")
+		}
+		if k.tracer != nil {
+			k.tracer.OnReturn(k.step, *k.Locals.Const)
 		}
 		if len(k.Stack) == 0 {
 			return false
@@ -321,7 +783,7 @@ func (k *KMachine) Step() bool {
 		val := k.Locals
 		top := k.popStack()
 		for top.pointer != nil {
-			*top.pointer = KClosure{Code: kRet, Env: k.Locals}
+			k.update(top.pointer, KClosure{Code: kRet, Env: k.Locals})
 			if len(k.Stack) == 0 {
 				return false
 			}
@@ -331,77 +793,255 @@ func (k *KMachine) Step() bool {
 		k.Locals = top.cl.Env
 		k.pushStack(kStackEntry{cl: KClosure{Code: kRet, Env: val}})
 	case *KIf:
-		top := k.popStack()
-		thenNode := k.popStack()
-		elseNode := k.popStack()
-		if top.cl.Code != kRet {
-			panic(top.cl)
-		}
-		cond := top.cl.Env.Const
-		if cond == nil {
-			panic(top.cl)
+		cond := k.peekValue(0)
+		thenNode, elseNode := k.peekClosure(1), k.peekClosure(2)
+		if cond.typ != LiteralBool {
+			k.failf("if: expect a bool condition, but found %v", cond)
 		}
+		k.Stack = k.Stack[:len(k.Stack)-3]
 		if cond.Bool() {
-			k.Code = thenNode.cl.Code
-			k.Locals = thenNode.cl.Env
+			k.Code = thenNode.Code
+			k.Locals = thenNode.Env
 		} else {
-			k.Code = elseNode.cl.Code
-			k.Locals = elseNode.cl.Env
+			k.Code = elseNode.Code
+			k.Locals = elseNode.Env
 		}
 	case *KApplyLeafFunction:
-		switch v.nArg {
-		case 2:
-			arg1 := k.popStack()
-			arg0 := k.popStack()
-			v0, v1 := arg0.cl.Literal(), arg1.cl.Literal()
-			k.Code = kRet
-			val := v.cb(v0, v1)
-			k.Locals = &kEnvFrame{Const: &val}
-		default:
-			panic(v)
+		// The arguments are on the top of the stack. The last one is at the top.
+		n := v.Op.nArg
+		args := make([]Literal, n)
+		for i := range args {
+			args[i] = k.peekValue(n - 1 - i)
 		}
+		k.chargeBigInts(args...)
+		val := k.fitInt(v.Op.cb(args...))
+		frame := k.newFrame(kEnvFrame{Const: &val})
+		k.Stack = k.Stack[:len(k.Stack)-n]
+		k.Code = kRet
+		k.Locals = frame
 	case *KSwapStack:
-		if v.N != 1 {
-			panic(v)
-		}
-		arg0, arg1, ret := k.popStack(), k.popStack(), k.popStack()
-		if arg0.pointer != nil || arg0.cl.Code != kRet {
-			panic(arg0)
+		if v.N < 1 {
+			k.failf("invalid swapstack:%d", v.N)
 		}
-		if arg1.pointer != nil {
-			panic(arg1)
+		for i := 0; i < v.N; i++ {
+			if e := k.peekStack(i); e.pointer != nil || e.cl.Code != kRet {
+				k.failf("swapstack: expect a value, but found %v", e)
+			}
 		}
-		k.Code = arg1.cl.Code
-		k.Locals = arg1.cl.Env
-		k.pushStack(arg0)
-		k.pushStack(ret)
+		next := k.peekClosure(v.N)
+		ret := k.peekStack(v.N + 1)
+		// Move the values below the next argument and the continuation.
+		base := len(k.Stack) - v.N - 2
+		copy(k.Stack[base:], k.Stack[base+2:])
+		k.Stack[base+v.N] = ret
+		k.Stack = k.Stack[:base+v.N+1]
+		k.Code = next.Code
+		k.Locals = next.Env
+	case *KConstruct:
+		k.stepConstruct(v)
+	case *KCase:
+		k.stepCase(v)
+	case *KFail:
+		k.failf("%s", v.Msg)
+	case *KBlackHole:
+		k.failLoop(v)
 	default:
 		return false
 	}
 	return true
 }
 
+// Compile compiles a toplevel expression. If node is an ASTAssign or ASTData,
+// the global or the constructors are defined in the machine. It panics if the
+// expression refers to an undefined variable or constructor.
 func (k *KMachine) Compile(node ASTNode) KCode {
 	var (
-		c = compiler{globals: &k.Globals}
+		c = compiler{globals: &k.Globals, cons: k.cons, funcs: k.funcs, scope: &scope{}}
 	)
 	return c.compile(node)
 }
 
+// CompileErr is similar to Compile, but it returns an error instead of
+// panicking.
+func (k *KMachine) CompileErr(node ASTNode) (code KCode, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			msg, ok := r.(string)
+			if !ok {
+				panic(r)
+			}
+			err = errors.New(msg)
+		}
+	}()
+	return k.Compile(node), nil
+}
+
+// CompileDefs compiles a group of toplevel definitions, e.g., those of a
+// script. Unlike a sequence of Compile calls, an assignment may refer to those
+// that follow it. The nodes should have been checked by TypeChecker.CheckDefs.
+// On error, the machine is left unchanged.
+func (k *KMachine) CompileDefs(nodes []ASTNode) (err error) {
+	globals := append([]kVarEntry(nil), k.Globals...)
+	cons := make(map[Symbol]*conSpec, len(k.cons))
+	for sym, spec := range k.cons {
+		cons[sym] = spec
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			msg, ok := r.(string)
+			if !ok {
+				panic(r)
+			}
+			k.Globals = globals
+			for sym := range k.cons {
+				if _, ok := cons[sym]; !ok {
+					delete(k.cons, sym)
+				}
+			}
+			for sym, spec := range cons {
+				k.cons[sym] = spec
+			}
+			err = errors.New(msg)
+		}
+	}()
+	var assigns []*ASTAssign
+	for _, node := range nodes {
+		switch v := node.(type) {
+		case *ASTData:
+			k.Compile(v)
+		case *ASTAssign:
+			assigns = append(assigns, v)
+		default:
+			panicf(v.Pos(), "toplevel expression %v is not a definition", v)
+		}
+	}
+	// Add the entries of all the globals before compiling any of them, so that
+	// they can refer to each other.
+	c := compiler{globals: &k.Globals, cons: k.cons, funcs: k.funcs}
+	for _, v := range assigns {
+		if _, ok := c.lookup(v.pos, v.Sym); !ok {
+			k.Globals = append(k.Globals, kVarEntry{sym: v.Sym})
+		}
+	}
+	for _, v := range assigns {
+		k.Compile(v)
+	}
+	return nil
+}
+
 type compiler struct {
 	// Points to KMachine.Globals
 	globals *[]kVarEntry
-	locals  [][]Symbol
+	// Points to KMachine.cons
+	cons map[Symbol]*conSpec
+	// Points to KMachine.funcs
+	funcs map[string]*funcSpec
+	// scope holds the local variables of the code being compiled.
+	scope *scope
+	// nFresh is used to generate unique symbols.
+	nFresh int
 }
 
-func (c *compiler) lookup(pos scanner.Position, sym Symbol) (addr KAddr, ok bool) {
-	for i, frame := range c.locals {
-		for j, name := range frame {
+// scope holds the local variables of a closure body, i.e., a lambda body, the
+// tail of an application, or a letrec binding. The outermost scope is that of
+// the toplevel expression.
+type scope struct {
+	parent *scope
+	// frames lists the local frames, innermost last. In a closure body, frames[0]
+	// is the environment of the closure. It holds nArgs arguments, followed by
+	// the variables captured from the parent scope.
+	frames [][]Symbol
+	nArgs  int
+	// captures[i] is the address in the parent scope of frames[0][nArgs+i].
+	captures []KAddr
+}
+
+// lookup finds a local variable. A variable of an enclosing scope is added to
+// the captured variables of s, and of the scopes in between.
+func (s *scope) lookup(sym Symbol) (KAddr, bool) {
+	if s == nil {
+		return KAddr{}, false
+	}
+	// Frame index 0 is the innermost frame, which is the last one in s.frames.
+	n := len(s.frames)
+	for i := n - 1; i >= 0; i-- {
+		for j, name := range s.frames[i] {
 			if sym == name {
-				return KAddr{frameIndex: uint32(i), varIndex: uint32(j)}, true
+				return KAddr{frameIndex: uint32(n - 1 - i), varIndex: uint32(j)}, true
 			}
 		}
 	}
+	addr, ok := s.parent.lookup(sym)
+	if !ok {
+		return KAddr{}, false
+	}
+	s.captures = append(s.captures, addr)
+	s.frames[0] = append(s.frames[0], sym)
+	return KAddr{frameIndex: uint32(n - 1), varIndex: uint32(len(s.frames[0]) - 1)}, true
+}
+
+func (s *scope) String() string {
+	var frames [][]Symbol
+	for ; s != nil; s = s.parent {
+		frames = append(append([][]Symbol{}, s.frames...), frames...)
+	}
+	return fmt.Sprint(frames)
+}
+
+// pushFrame adds a frame of local variables to the current scope.
+func (c *compiler) pushFrame(syms []Symbol) {
+	c.scope.frames = append(c.scope.frames, syms)
+}
+
+func (c *compiler) popFrame() {
+	c.scope.frames = c.scope.frames[:len(c.scope.frames)-1]
+}
+
+// compileClosure compiles the body of a closure with the given arguments in a
+// new scope. It returns the code and the addresses of the captured variables in
+// the current scope.
+func (c *compiler) compileClosure(args []Symbol, body func() KCode) (KCode, []KAddr) {
+	s := &scope{parent: c.scope, frames: [][]Symbol{append([]Symbol{}, args...)}, nArgs: len(args)}
+	c.scope = s
+	defer func() { c.scope = s.parent }()
+	code := body()
+	return code, s.captures
+}
+
+// compileLambda compiles a lambda whose body is generated by body().
+func (c *compiler) compileLambda(pos scanner.Position, arg Symbol, body func() KCode) *KLambda {
+	code, captures := c.compileClosure([]Symbol{arg}, body)
+	return &KLambda{pos: pos, Arg: arg, Body: code, Captures: captures}
+}
+
+// apply compiles an application of head to the closure generated by tail().
+func (c *compiler) apply(pos scanner.Position, head KCode, tail func() KCode) *KApply {
+	code, captures := c.compileClosure(nil, tail)
+	return &KApply{pos: pos, Head: head, Tail: code, Captures: captures}
+}
+
+// letrec compiles a letrec that binds syms to the closures generated by
+// exprs. The closures and body() are compiled in the scope of syms.
+func (c *compiler) letrec(pos scanner.Position, syms []Symbol, exprs []func() KCode, body func() KCode) *KLetrec {
+	c.pushFrame(syms)
+	defer c.popFrame()
+	k := &KLetrec{
+		pos:      pos,
+		VarNames: syms,
+		VarExprs: make([]KCode, len(syms)),
+		Captures: make([][]KAddr, len(syms)),
+	}
+	for i, expr := range exprs {
+		k.VarExprs[i], k.Captures[i] = c.compileClosure(nil, expr)
+	}
+	k.Body = body()
+	return k
+}
+
+func (c *compiler) lookup(pos scanner.Position, sym Symbol) (addr KAddr, ok bool) {
+	if addr, ok := c.scope.lookup(sym); ok {
+		return addr, true
+	}
 	for j, e := range *c.globals {
 		if sym == e.sym {
 			return KAddr{frameIndex: kGlobalFrame, varIndex: uint32(j)}, true
@@ -410,70 +1050,109 @@ func (c *compiler) lookup(pos scanner.Position, sym Symbol) (addr KAddr, ok bool
 	return KAddr{}, false
 }
 
+func (c *compiler) compileVar(pos scanner.Position, sym Symbol) KCode {
+	addr, ok := c.lookup(pos, sym)
+	if !ok {
+		if op, ok := c.funcs[sym.String()]; ok {
+			return c.compileBuiltin(pos, op)
+		}
+		if op, ok := funcs[sym.String()]; ok {
+			return c.compileBuiltin(pos, op)
+		}
+		panicf(pos, "variable %v not found in %v", sym, c.scope)
+	}
+	return &KVar{pos: pos, Addr: addr}
+}
+
+// compileBuiltin compiles a reference to a builtin or registered function by
+// name, e.g., "strlen". A builtin of n arguments becomes a lambda of n arguments.
+// A builtin of no argument is called right away.
+func (c *compiler) compileBuiltin(pos scanner.Position, op *funcSpec) KCode {
+	if op.nArg == 0 {
+		return c.compile(&ASTApplyLeafFunction{pos: pos, Op: op})
+	}
+	var (
+		args     []string
+		argExprs []ASTNode
+	)
+	for i := 0; i < op.nArg; i++ {
+		arg := fmt.Sprintf("$%d", i)
+		args = append(args, arg)
+		argExprs = append(argExprs, &ASTVar{pos: pos, Sym: InternSymbol(arg)})
+	}
+	return c.compile(newLambda(pos, args, &ASTApplyLeafFunction{pos: pos, Op: op, Args: argExprs}))
+}
+
 func (c *compiler) compile(node ASTNode) KCode {
 	switch v := node.(type) {
 	case *ASTAssign:
 		addr, ok := c.lookup(v.pos, v.Sym)
-		cl := KClosure{Code: c.compile(v.Expr), Env: nil}
 		if !ok {
-			*c.globals = append(
-				*c.globals,
-				kVarEntry{sym: v.Sym, cl: cl})
-		} else {
-			if addr.frameIndex != kGlobalFrame {
-				panicf(v.pos, "local variable found where global is expected:%v", v.Sym)
-			}
-			(*c.globals)[addr.varIndex].cl = cl
+			// Add the entry before compiling the expression so that the expression
+			// can refer to itself.
+			*c.globals = append(*c.globals, kVarEntry{sym: v.Sym})
+			addr = KAddr{frameIndex: kGlobalFrame, varIndex: uint32(len(*c.globals) - 1)}
+			// Remove the entry if the expression fails to compile, so that the
+			// global stays undefined.
+			defer func(n int) {
+				if r := recover(); r != nil {
+					*c.globals = (*c.globals)[:n]
+					panic(r)
+				}
+			}(len(*c.globals) - 1)
+		} else if addr.frameIndex != kGlobalFrame {
+			panicf(v.pos, "local variable found where global is expected:%v", v.Sym)
 		}
+		cl := KClosure{Code: c.compile(v.Expr), Env: nil}
+		(*c.globals)[addr.varIndex].cl = cl
 		return cl.Code
 	case *ASTConst:
-		return (*KConst)(&v.Val)
+		return &KConst{pos: v.pos, Val: v.Val}
 	case *ASTLambda:
 		mustf(v.pos, v.Arg.string != nil, "v:%v", v)
-		c.locals = append(c.locals, []Symbol{v.Arg})
-		defer func() { c.locals = c.locals[:len(c.locals)-1] }()
-		return &KLambda{Arg: v.Arg, Body: c.compile(v.Body)}
+		return c.compileLambda(v.pos, v.Arg, func() KCode { return c.compile(v.Body) })
 	case *ASTVar:
-		addr, ok := c.lookup(v.pos, v.Sym)
-		if !ok {
-			panicf(v.pos, "variable %v not found in %+v", v.Sym, c.locals)
-		}
-		return &KVar{Addr: addr}
+		return c.compileVar(v.pos, v.Sym)
+	case *ASTCon:
+		return c.compileCon(v)
+	case *ASTCase:
+		return c.compileCase(v)
+	case *ASTData:
+		return c.compileData(v)
 	case *ASTApply:
-		return &KApply{Head: c.compile(v.Head), Tail: c.compile(v.Tail)}
+		return c.apply(v.pos, c.compile(v.Head), func() KCode { return c.compile(v.Tail) })
 	case *ASTApplyLeafFunction:
-		if len(v.Args) == 1 {
-			return &KApply{Head: c.compile(v.Args[0]), Tail: (*KApplyLeafFunction)(v.Op)}
+		// The arguments are evaluated from left to right. Each one but the last
+		// returns to a KSwapStack, which starts the next one. The last one
+		// returns to the builtin.
+		var code KCode = &KApplyLeafFunction{pos: v.pos, Op: v.Op}
+		n := len(v.Args)
+		if n == 0 {
+			return code
 		}
-		if len(v.Args) == 2 {
-			c0 := &KApply{Head: c.compile(v.Args[0]), Tail: &KSwapStack{1}}
-			c1 := &KApply{Head: c0, Tail: c.compile(v.Args[1])}
-			return &KApply{Head: c1, Tail: (*KApplyLeafFunction)(v.Op)}
+		head := c.compile(v.Args[0])
+		for i := 1; i < n; i++ {
+			head = c.apply(v.pos, head, func() KCode { return &KSwapStack{pos: v.pos, N: i} })
+			head = c.apply(v.pos, head, func() KCode { return c.compile(v.Args[i]) })
 		}
-		panic("blah")
+		return c.apply(v.pos, head, func() KCode { return code })
 	case *ASTLetrec:
-		n := len(v.Bindings)
-		var frame []Symbol
-		for _, b := range v.Bindings {
-			frame = append(frame, b.Sym)
-		}
-		c.locals = append(c.locals, frame)
 		var (
-			varNames = make([]Symbol, 0, n)
-			varExprs = make([]KCode, 0, n)
+			syms  = make([]Symbol, len(v.Bindings))
+			exprs = make([]func() KCode, len(v.Bindings))
 		)
-		for _, b := range v.Bindings {
-			varNames = append(varNames, b.Sym)
-			varExprs = append(varExprs, c.compile(b.Expr))
+		for i, b := range v.Bindings {
+			b := b
+			syms[i] = b.Sym
+			exprs[i] = func() KCode { return c.compile(b.Expr) }
 		}
-		defer func() { c.locals = c.locals[:len(c.locals)-1] }()
-		return &KLetrec{VarNames: varNames, VarExprs: varExprs, Body: c.compile(v.Body)}
+		return c.letrec(v.pos, syms, exprs, func() KCode { return c.compile(v.Body) })
 	case *ASTIf:
-		return &KApply{
-			Head: &KApply{
-				Head: &KApply{Head: c.compile(v.Cond), Tail: kIf},
-				Tail: c.compile(v.Then)},
-			Tail: c.compile(v.Else)}
+		head := c.apply(v.pos, c.compile(v.Cond), func() KCode { return &KIf{pos: v.pos} })
+		head = c.apply(v.pos, head, func() KCode { return c.compile(v.Then) })
+		return c.apply(v.pos, head, func() KCode { return c.compile(v.Else) })
+	case *ASTModule, *ASTImport:
+		panicf(v.Pos(), "%v must be resolved by a ModuleLoader", v)
 	}
 	panic(node)
 }
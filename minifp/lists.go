@@ -0,0 +1,109 @@
+package minifp
+
+import (
+	"strings"
+)
+
+// Lists are a builtin data type with constructors "[]" and ":". "[1, 2]" is a
+// shorthand for "1 : 2 : []". Like the fields of other constructors, the head
+// and the tail of a cons cell are evaluated lazily, so a list can be infinite.
+var (
+	nilSpec  = &conSpec{sym: InternSymbol("[]"), tag: 0, arity: 0}
+	consSpec = &conSpec{sym: InternSymbol(":"), tag: 1, arity: 2}
+)
+
+// listTypeName is the name of the list type constructor. Type "[a]" is
+// represented as TypeCon{Name: "[]", Args: {a}}.
+const listTypeName = "[]"
+
+func newListType(elem Type) Type { return &TypeCon{Name: listTypeName, Args: []Type{elem}} }
+
+// listConTypes returns the types of the list constructors.
+func listConTypes() map[Symbol]Type {
+	a := &TypeVar{level: genericLevel}
+	return map[Symbol]Type{
+		nilSpec.sym:  newListType(a),
+		consSpec.sym: newFuncType(a, newFuncType(newListType(a), newListType(a))),
+	}
+}
+
+// formatList renders a list whose first cell is c. A list that ends with "[]"
+// is rendered as "[e0, e1, ...]". Otherwise, e.g., if the tail hasn't been
+// evaluated, it is rendered as "e0 : e1 : tail". A cyclic tail, or a tail that
+// hasn't been forced, is rendered as "...".
+func formatList(buf *strings.Builder, c *conValue, nested bool, visiting map[*conValue]bool) {
+	var (
+		cells  []*conValue
+		tail   KClosure
+		proper bool
+		elided bool
+	)
+	for {
+		visiting[c] = true
+		cells = append(cells, c)
+		tail = c.fields[1].cl
+		if tail.Code != kRet {
+			break
+		}
+		next := tail.Env.Const.con
+		if next.spec == nilSpec {
+			proper = true
+			break
+		}
+		if visiting[next] || next.unforced() {
+			elided = true
+			break
+		}
+		c = next
+	}
+	if proper {
+		buf.WriteRune('[')
+		for i, c := range cells {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			formatField(buf, c.fields[0].cl, false, visiting)
+		}
+		buf.WriteRune(']')
+	} else {
+		if nested {
+			buf.WriteRune('(')
+		}
+		for _, c := range cells {
+			formatField(buf, c.fields[0].cl, true, visiting)
+			buf.WriteString(" : ")
+		}
+		if elided {
+			buf.WriteString("...")
+		} else {
+			formatField(buf, tail, true, visiting)
+		}
+		if nested {
+			buf.WriteRune(')')
+		}
+	}
+	for _, c := range cells {
+		delete(visiting, c)
+	}
+}
+
+// newListValue creates a list of the given values.
+func newListValue(elems []Literal) Literal {
+	list := Literal{typ: LiteralCon, con: &conValue{spec: nilSpec}}
+	for i := len(elems) - 1; i >= 0; i-- {
+		cell := &conValue{spec: consSpec, fields: []*kVarEntry{
+			{sym: consFieldSyms[0], cl: valueClosure(elems[i])},
+			{sym: consFieldSyms[1], cl: valueClosure(list)},
+		}}
+		list = Literal{typ: LiteralCon, con: cell}
+	}
+	return list
+}
+
+// consFieldSyms names the fields of a cons cell created by newListValue.
+var consFieldSyms = [2]Symbol{InternSymbol("$0"), InternSymbol("$1")}
+
+// valueClosure creates a closure that evaluates to val.
+func valueClosure(val Literal) KClosure {
+	return KClosure{Code: kRet, Env: &kEnvFrame{Const: &val}}
+}
@@ -0,0 +1,134 @@
+package minifp_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+func TestList(t *testing.T) {
+	km := minifp.NewMachine()
+	expect.EQ(t, run(t, km, `[]`).String(), "[]")
+	expect.EQ(t, run(t, km, `[1, 2, 1+2]`).String(), "[1, 2, 3]")
+	expect.EQ(t, run(t, km, `1 : 2 : []`).String(), "[1, 2]")
+	expect.EQ(t, run(t, km, `(:) 1 []`).String(), "[1]")
+	expect.EQ(t, run(t, km, `[[1], [], [2, 3]]`).String(), "[[1], [], [2, 3]]")
+	expect.EQ(t, run(t, km, `[1 : [], 2 : []]`).String(), "[[1], [2]]")
+	// ':' binds more loosely than '+', and more tightly than '=='.
+	expect.EQ(t, run(t, km, `1 + 1 : []`).String(), "[2]")
+	expect.EQ(t, run(t, km, `data Maybe a = Nothing | Just a; [Just 1, Nothing]`).String(), "[Just 1, Nothing]")
+	expect.EQ(t, run(t, km, `Just [1, 2]`).String(), "Just [1, 2]")
+	expect.EQ(t, run(t, km, `letrec xs = 1 : 2 : xs in xs`).String(), "1 : 2 : ...")
+}
+
+func TestListCase(t *testing.T) {
+	km := minifp.NewMachine()
+	run(t, km, `
+sum xs = case xs of {[] -> 0; x : rest -> x + sum rest};
+firstTwo xs = case xs of {x : y : _ -> x + y; [x] -> x; [] -> 0};
+isPair xs = case xs of {[_, _] -> 1 == 1; _ -> 1 == 0}`)
+	expect.EQ(t, run(t, km, `sum [1, 2, 3]`).String(), "6")
+	expect.EQ(t, run(t, km, `firstTwo [10, 20, 30]`).String(), "30")
+	expect.EQ(t, run(t, km, `firstTwo [10]`).String(), "10")
+	expect.EQ(t, run(t, km, `firstTwo []`).String(), "0")
+	expect.EQ(t, run(t, km, `isPair [1, 2]`).String(), "true")
+	expect.EQ(t, run(t, km, `isPair [1, 2, 3]`).String(), "false")
+}
+
+func TestListPrelude(t *testing.T) {
+	km := minifp.NewMachine()
+	for _, test := range []struct{ src, want string }{
+		{`take 5 (iterate (\x -> x*2) 1)`, "[1, 2, 4, 8, 16]"},
+		{`map (\x -> x*x) [1, 2, 3]`, "[1, 4, 9]"},
+		{`filter (\x -> x > 1) [3, 1, 2]`, "[3, 2]"},
+		{`foldr (\x acc -> x : acc) [] [1, 2, 3]`, "[1, 2, 3]"},
+		{`foldl (\acc x -> x : acc) [] [1, 2, 3]`, "[3, 2, 1]"},
+		{`foldl (\acc x -> acc - x) 10 [1, 2, 3]`, "4"},
+		{`take 2 [1]`, "[1]"},
+		{`drop 2 [1, 2, 3]`, "[3]"},
+		{`drop 5 [1, 2, 3]`, "[]"},
+		{`zip [1, 2, 3] [1 == 1, 1 == 2]`, "[Pair 1 true, Pair 2 false]"},
+		{`length (range 0 100)`, "100"},
+		{`range 3 6`, "[3, 4, 5]"},
+		{`range 3 3`, "[]"},
+		{`take 3 (drop 10 (map (\x -> x*x) (iterate (\x -> x+1) 0)))`, "[100, 121, 144]"},
+	} {
+		expect.EQ(t, run(t, km, test.src).String(), test.want, test.src)
+	}
+}
+
+func TestListPreludeShared(t *testing.T) {
+	// The prelude is compiled and type-checked once for all machines and type
+	// checkers. Redefining a prelude function in one of them doesn't affect the
+	// others.
+	km0, km1 := minifp.NewMachine(), minifp.NewMachine()
+	expect.EQ(t, run(t, km0, `length xs = 42; length [1]`).String(), "42")
+	expect.EQ(t, run(t, km1, `length [1]`).String(), "1")
+
+	tc0, tc1 := minifp.NewTypeChecker(), minifp.NewTypeChecker()
+	_, err := tc0.Check(minifp.Parse(strings.NewReader(`length = 1`))[0])
+	expect.NoError(t, err)
+	typ, err := tc1.Check(minifp.Parse(strings.NewReader(`length`))[0])
+	expect.NoError(t, err)
+	expect.EQ(t, typ.String(), "[a] -> Int")
+}
+
+func TestListLaziness(t *testing.T) {
+	km := minifp.NewMachine(minifp.MaxSteps(100000))
+	run(t, km, `loop x = loop x`)
+	// The elements are not evaluated by length.
+	expect.EQ(t, run(t, km, `length [loop 1, loop 2]`).String(), "2")
+	// The tail of an infinite list is evaluated only as far as needed.
+	expect.EQ(t, run(t, km, `letrec ones = 1 : ones in take 3 ones`).String(), "[1, 1, 1]")
+	expect.EQ(t, run(t, km, `case 1 : loop 1 of {x : _ -> x}`).String(), "1")
+
+	// With MaxForce, only a prefix of the result is evaluated.
+	km = minifp.NewMachine(minifp.MaxForce(3))
+	expect.EQ(t, run(t, km, `iterate (\x -> x + 1) 0`).String(), "0 : 1 : 2 : ...")
+	expect.EQ(t, run(t, km, `[1, 2, 3]`).String(), "[1, 2, 3]")
+	// The values are forced breadth-first.
+	expect.EQ(t, run(t, km, `[iterate (\x -> x) 1, [2]]`).String(), "[1 : ..., ...]")
+	expect.EQ(t, run(t, km, `Pair (iterate (\x -> x) 1) [2]`).String(), "Pair (1 : ...) [2]")
+}
+
+func TestListTypes(t *testing.T) {
+	for _, test := range []struct{ src, want string }{
+		{`[]`, "[a]"},
+		{`[1, 2]`, "[Int]"},
+		{`[[1 == 1]]`, "[[Bool]]"},
+		{`(:)`, "a -> [a] -> [a]"},
+		{`map`, "(a -> b) -> [a] -> [b]"},
+		{`foldr`, "(a -> b -> b) -> b -> [a] -> b"},
+		{`zip`, "[a] -> [b] -> [Pair a b]"},
+		{`iterate`, "(a -> a) -> a -> [a]"},
+		{`\xs -> case xs of {x : _ -> x + 1; [] -> 0}`, "[Int] -> Int"},
+		{`data Tree a = Node a [Tree a]; Node`, "a -> [Tree a] -> Tree a"},
+	} {
+		got, err := typeOf(t, test.src)
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, got, test.want, test.src)
+	}
+	for _, test := range []struct{ src, want string }{
+		{`[1, 1 == 1]`, "type mismatch: expect [Int], but found [Bool]"},
+		{`1 : 2`, "type mismatch: expect [Int], but found Int"},
+		{`\xs -> case xs of {[x] -> x; 1 -> 1}`, "type mismatch: expect [a], but found Int"},
+	} {
+		_, err := typeOf(t, test.src)
+		expect.True(t, err != nil, test.src)
+		if err != nil {
+			expect.HasSubstr(t, err.Error(), test.want, test.src)
+		}
+	}
+}
+
+func TestListParse(t *testing.T) {
+	nodes := minifp.Parse(strings.NewReader(`[1, x]; case xs of {[] -> 0; x : _ : rest -> x; [a, b] -> b}`))
+	expect.EQ(t, nodes[0].String(), "(((:) 1) (((:) x) []))")
+	expect.EQ(t, nodes[1].String(), "case xs of {[] -> 0; (x : (_ : rest)) -> x; (a : (b : [])) -> b}")
+	for _, node := range nodes {
+		reparsed := minifp.Parse(strings.NewReader(node.String()))
+		expect.EQ(t, reparsed[0].String(), node.String())
+	}
+}
@@ -1,6 +1,10 @@
 package minifp_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"strings"
 	"testing"
@@ -78,3 +82,276 @@ func TestLetrec(t *testing.T) {
 	expect.EQ(t, run(t, km, `letrec x=10; y=x+1 in x*y`).String(), "110")
 	expect.EQ(t, run(t, km, `letrec x=10 in (letrec y=12 in x*y)`).String(), "120")
 }
+
+func TestParseErr(t *testing.T) {
+	_, err := minifp.ParseErr(strings.NewReader(`letrec x = 1 x`))
+	var synErr *minifp.SyntaxError
+	expect.True(t, errors.As(err, &synErr))
+	expect.EQ(t, synErr.Pos.Line, 1)
+	expect.EQ(t, synErr.Pos.Column, 15)
+	expect.EQ(t, synErr.Token, "")
+	expect.EQ(t, synErr.Expected, []string{"in", ";"})
+	expect.EQ(t, err.Error(), "<input>:1:15: syntax error: unexpected EOF, expecting in or ;")
+
+	_, err = minifp.ParseErr(strings.NewReader(`10 + ) ; x = 1 $ 2; 3`))
+	errs := err.(minifp.ErrorList)
+	expect.EQ(t, len(errs), 2)
+	expect.EQ(t, errs[0].Token, ")")
+	expect.EQ(t, errs[0].Pos.Column, 6)
+	expect.EQ(t, errs[1].Token, "$")
+	expect.EQ(t, errs[1].Pos.Column, 16)
+
+	nodes, err := minifp.ParseErr(strings.NewReader(`99999999999999999999`))
+	expect.NoError(t, err)
+	expect.EQ(t, nodes[0].String(), "99999999999999999999")
+
+	_, err = minifp.ParseFile("foo.mfp", strings.NewReader(`1 +`))
+	expect.HasSubstr(t, err.Error(), "foo.mfp:1:4: syntax error")
+
+	nodes, err = minifp.ParseErr(strings.NewReader(`x = 1; x+2`))
+	expect.NoError(t, err)
+	expect.EQ(t, len(nodes), 2)
+}
+
+func TestGrammar(t *testing.T) {
+	// Function application is left-associative. It used to be
+	// right-associative, so "f x y" was a syntax error and "f (g x) y" was "f
+	// ((g x) y)".
+	for _, test := range []struct{ src, want string }{
+		{`f x y`, "((f x) y)"},
+		{`f (g x) y`, "((f (g x)) y)"},
+		{`f x y = x; f`, "f"},
+	} {
+		nodes, err := minifp.ParseErr(strings.NewReader(test.src))
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, nodes[len(nodes)-1].String(), test.want, test.src)
+	}
+
+	// Application binds tighter than operators, and arithmetic binds tighter
+	// than comparisons. "(\x -> x * 2) 1 + 2" used to be 6, and "1 + 2 == 3"
+	// used to be "1 + (2 == 3)".
+	km := minifp.NewMachine()
+	expect.EQ(t, run(t, km, `(\x -> x * 2) 1 + 2`).String(), "4")
+	expect.EQ(t, run(t, km, `1 + 2 == 3`).String(), "true")
+
+	// The rest of the grammar is unchanged. The else branch of "if" extends as
+	// far as possible, and comparisons group to the right.
+	for _, test := range []struct{ src, want string }{
+		{`if c f x y`, "(if c f (x y))"},
+		{`if c (f x) y`, "(if c (f x) y)"},
+	} {
+		nodes, err := minifp.ParseErr(strings.NewReader(test.src))
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, nodes[0].String(), test.want, test.src)
+	}
+	expect.EQ(t, run(t, km, `if (1 == 2) 2 3 + 4`).String(), "7")
+	x := minifp.Parse(strings.NewReader(`a < b < c`))[0].(*minifp.ASTApplyLeafFunction)
+	expect.EQ(t, x.Args[0].String(), "a")
+	_, ok := x.Args[1].(*minifp.ASTApplyLeafFunction)
+	expect.True(t, ok)
+	// The condition of "if" must be an atom.
+	_, err := minifp.ParseErr(strings.NewReader(`if 1 == 1 2 3`))
+	expect.HasSubstr(t, err.Error(), "syntax error")
+}
+
+func TestRunErr(t *testing.T) {
+	km := minifp.NewMachine()
+	x := minifp.Parse(strings.NewReader(`1 + (10 == 10)`))
+	_, err := km.RunErr(km.Compile(x[0]))
+	var runErr *minifp.RuntimeError
+	expect.True(t, errors.As(err, &runErr))
+	expect.EQ(t, runErr.Code.DebugString(), "builtin:+")
+	expect.GT(t, runErr.Step, 0)
+	expect.HasSubstr(t, runErr.Msg, "expect an int, but found true")
+	expect.HasSubstr(t, runErr.Stack, "ret:1")
+
+	x = minifp.Parse(strings.NewReader(`if 1 2 3`))
+	_, err = km.RunErr(km.Compile(x[0]))
+	expect.HasSubstr(t, err.Error(), "if: expect a bool condition, but found 1")
+
+	x = minifp.Parse(strings.NewReader(`x = y`))
+	_, err = km.CompileErr(x[0])
+	expect.HasSubstr(t, err.Error(), "variable y not found")
+	// The failed definition doesn't leave x defined.
+	x = minifp.Parse(strings.NewReader(`x`))
+	_, err = km.CompileErr(x[0])
+	expect.HasSubstr(t, err.Error(), "variable x not found")
+
+	// The machine remains usable after an error.
+	expect.EQ(t, run(t, km, `10+11`).String(), "21")
+}
+
+func TestPositions(t *testing.T) {
+	nodes := minifp.Parse(strings.NewReader("x = 1;\n  if (x == 1) 3 ((\\y z -> y) 20)"))
+	assign := nodes[0].(*minifp.ASTAssign)
+	expect.EQ(t, assign.Pos().String(), "<input>:1:1")
+	expect.EQ(t, assign.Expr.Pos().String(), "<input>:1:5")
+	ifNode := nodes[1].(*minifp.ASTIf)
+	expect.EQ(t, ifNode.Pos().String(), "<input>:2:3")
+	expect.EQ(t, ifNode.Cond.Pos().String(), "<input>:2:7")
+	apply := ifNode.Else.(*minifp.ASTApply)
+	expect.EQ(t, apply.Pos().String(), "<input>:2:18")
+	lambda := apply.Head.(*minifp.ASTLambda)
+	expect.EQ(t, lambda.Pos().String(), "<input>:2:19")
+	expect.EQ(t, lambda.Body.Pos().String(), "<input>:2:19")
+	expect.EQ(t, apply.Tail.Pos().String(), "<input>:2:30")
+
+	km := minifp.NewMachine()
+	km.Compile(nodes[0])
+	code := km.Compile(nodes[1])
+	expect.EQ(t, code.Pos().String(), "<input>:2:3")
+
+	x := minifp.Parse(strings.NewReader("\n  1 + (10 == 10)"))
+	_, err := km.RunErr(km.Compile(x[0]))
+	expect.EQ(t, err.(*minifp.RuntimeError).Pos.String(), "<input>:2:3")
+}
+
+func TestLimits(t *testing.T) {
+	run := func(km *minifp.KMachine, ctx context.Context, expr string) error {
+		var err error
+		for _, node := range minifp.Parse(strings.NewReader(expr)) {
+			_, err = km.RunContext(ctx, km.Compile(node))
+		}
+		return err
+	}
+	const loop = `f x = f x; f 1`
+	err := run(minifp.NewMachine(minifp.MaxSteps(1000)), context.Background(), loop)
+	expect.True(t, errors.Is(err, minifp.ErrStepLimit), err)
+	expect.EQ(t, err.(*minifp.RuntimeError).Step, 1000)
+
+	err = run(minifp.NewMachine(minifp.MaxEnvFrames(100)), context.Background(), loop)
+	expect.True(t, errors.Is(err, minifp.ErrEnvFrameLimit), err)
+
+	err = run(minifp.NewMachine(minifp.MaxStackDepth(100)), context.Background(), `g x = 1 + g x; g 1`)
+	expect.True(t, errors.Is(err, minifp.ErrStackLimit), err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = run(minifp.NewMachine(), ctx, loop)
+	expect.True(t, errors.Is(err, context.Canceled), err)
+	// The cancellation is detected at the first step.
+	expect.EQ(t, err.(*minifp.RuntimeError).Step, 1)
+
+	km := minifp.NewMachine(minifp.MaxSteps(1000))
+	expect.EQ(t, run(km, context.Background(), `h x = x + 1; h 10`), nil)
+}
+
+func TestBlackHole(t *testing.T) {
+	km := minifp.NewMachine()
+	runErr := func(expr string) error {
+		x := minifp.Parse(strings.NewReader(expr))
+		_, err := km.RunErr(km.Compile(x[0]))
+		return err
+	}
+	err := runErr(`letrec x = x in x`)
+	expect.True(t, errors.Is(err, minifp.ErrLoop), err)
+	expect.HasSubstr(t, err.Error(), "<<loop>>: x (defined at <input>:1:12) depends on its own value")
+	expect.EQ(t, err.(*minifp.RuntimeError).Pos.String(), "<input>:1:12")
+
+	err = runErr(`letrec a = b + 1; b = 2 * a in a`)
+	expect.True(t, errors.Is(err, minifp.ErrLoop), err)
+	expect.HasSubstr(t, err.Error(), "<<loop>>: a (defined at")
+
+	err = runErr(`y = y * 2`)
+	expect.True(t, errors.Is(err, minifp.ErrLoop), err)
+	expect.HasSubstr(t, err.Error(), "<<loop>>: y (defined at <input>:1:5)")
+	err = runErr(`y + 1`)
+	expect.True(t, errors.Is(err, minifp.ErrLoop), err)
+
+	// A thunk that fails for another reason is not left as a black hole.
+	run(t, km, `bad u = 1 / 0`)
+	for i := 0; i < 2; i++ {
+		err = runErr(`letrec z = bad 0 in z + z`)
+		expect.True(t, errors.Is(err, minifp.ErrDivisionByZero), err)
+	}
+	km2 := minifp.NewMachine(minifp.MaxSteps(1000))
+	nodes := minifp.Parse(strings.NewReader(`n = length (range 0 1000); n + 1`))
+	km2.Compile(nodes[0])
+	code := km2.Compile(nodes[1])
+	for i := 0; i < 2; i++ {
+		_, err = km2.RunErr(code)
+		expect.True(t, errors.Is(err, minifp.ErrStepLimit), err)
+	}
+
+	// Lazy self-references are fine.
+	expect.EQ(t, run(t, km, `letrec xs = 1 : xs in take 3 xs`).String(), "[1, 1, 1]")
+	expect.EQ(t, run(t, km, `letrec f n = if (n == 0) 1 (n * f (n - 1)) in f 5`).String(), "120")
+}
+
+func TestTracer(t *testing.T) {
+	var text, js bytes.Buffer
+	km := minifp.NewMachine(minifp.WithTracer(minifp.NewTextTracer(&text)))
+	expect.EQ(t, run(t, km, `x = 10; x+1`).String(), "11")
+	lines := strings.Split(strings.TrimSpace(text.String()), "\n")
+	expect.EQ(t, lines[0], "1: const:10 [] []")
+	expect.EQ(t, lines[len(lines)-1], "11: ret [const:11] []")
+
+	km.SetTracer(minifp.NewJSONTracer(&js))
+	expect.EQ(t, run(t, km, `y = 2; x+y`).String(), "12")
+	var events []map[string]interface{}
+	dec := json.NewDecoder(&js)
+	for dec.More() {
+		var ev map[string]interface{}
+		expect.NoError(t, dec.Decode(&ev))
+		events = append(events, ev)
+	}
+	expect.EQ(t, events[0]["event"], "step")
+	expect.EQ(t, events[0]["pos"], "<input>:1:5")
+	var updated []string
+	for _, ev := range events {
+		if ev["event"] == "update" {
+			updated = append(updated, ev["sym"].(string))
+		}
+	}
+	expect.EQ(t, updated, []string{"y"})
+	expect.EQ(t, events[len(events)-1]["event"], "return")
+	expect.EQ(t, events[len(events)-1]["value"], "12")
+
+	// The global x was forced by the first run.
+	km.SetTracer(nil)
+	text.Reset()
+	expect.EQ(t, run(t, km, `x`).String(), "10")
+	expect.EQ(t, text.Len(), 0)
+}
+
+func TestBoolOps(t *testing.T) {
+	km := minifp.NewMachine()
+	run(t, km, `boom u = case [] of {x : _ -> x}`)
+	for _, test := range []struct{ src, want string }{
+		{`1 < 2 && 2 < 3`, "true"},
+		{`1 < 2 && 3 < 2`, "false"},
+		{`1 > 2 || 1 == 1`, "true"},
+		{`1 > 2 || 2 > 3`, "false"},
+		// && binds tighter than ||, and both are looser than comparisons.
+		{`1 == 1 || 1 == 1 && 1 == 2`, "true"},
+		{`(1 == 1 || 1 == 1) && 1 == 2`, "false"},
+		{`not (1 == 1) || 1 == 1`, "true"},
+		{`not (1 == 1 && 1 == 2)`, "true"},
+		{`"a" ++ "b" == "ab" && 1 + 1 == 2`, "true"},
+		// The right operand is evaluated only if needed.
+		{`1 == 2 && boom 0`, "false"},
+		{`1 == 1 || boom 0`, "true"},
+		{`filter (\x -> x > 1 && x < 4) (range 0 10)`, "[2, 3]"},
+		{`letrec even n = n == 0 || odd (n - 1); odd n = n != 0 && even (n - 1) in even 10`, "true"},
+	} {
+		expect.EQ(t, run(t, km, test.src).String(), test.want, test.src)
+	}
+
+	// The language has no boolean literals; the operators desugar to
+	// comparisons.
+	x := minifp.Parse(strings.NewReader(`a && b || c`))
+	expect.EQ(t, x[0].String(), "(if (if a b (0 == 1)) (0 == 0) c)")
+
+	x = minifp.Parse(strings.NewReader(`1 == 1 && boom 0`))
+	_, err := km.RunErr(km.Compile(x[0]))
+	expect.HasSubstr(t, err.Error(), "non-exhaustive patterns in case")
+
+	got, err := typeOf(t, `\x y -> x && y || not x`)
+	expect.NoError(t, err)
+	expect.EQ(t, got, "Bool -> Bool -> Bool")
+	_, err = typeOf(t, `1 && 1 == 1`)
+	expect.HasSubstr(t, err.Error(), "type mismatch: expect Bool, but found Int")
+
+	_, err = minifp.ParseErr(strings.NewReader(`x & y`))
+	expect.HasSubstr(t, err.Error(), `invalid token "&"`)
+}
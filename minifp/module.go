@@ -0,0 +1,375 @@
+package minifp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/scanner"
+)
+
+// ModuleError describes an error found by ModuleLoader, e.g., a missing module
+// or an import cycle.
+type ModuleError struct {
+	Pos scanner.Position
+	Msg string
+}
+
+func (e *ModuleError) Error() string { return e.Pos.String() + ": " + e.Msg }
+
+// ModuleLoader resolves module and import declarations.
+//
+// A file that starts with "module Foo" defines module Foo. Its toplevel
+// definitions are stored in the machine under their qualified names, e.g.,
+// "Foo.x", so modules don't clash with each other. "import Foo" makes all the
+// definitions of Foo visible in the importing file, and "import Foo (x, y)"
+// makes only x and y visible. In both cases, every definition of Foo can be
+// referred to by its qualified name, e.g., "Foo.z". Data types and constructors
+// are not qualified; they are shared by all modules, so two modules cannot
+// declare the same one.
+//
+// The loader reads each module once, even if it is imported by several files.
+type ModuleLoader struct {
+	// Path lists the directories searched for an imported module. Module Foo is
+	// read from file Foo.mfp in the first directory that has one.
+	Path []string
+	// modules holds the modules loaded so far, by name.
+	modules map[string]*module
+	// dataDecls maps "data type T" and "constructor C" to the declaration of
+	// data type T or constructor C.
+	dataDecls map[string]dataDecl
+	// loading is the chain of imports being loaded, to detect cycles.
+	loading []string
+	// root is the scope of the expressions passed to Load.
+	root *moduleScope
+}
+
+// dataDecl is the declaration of a data type or a constructor.
+type dataDecl struct {
+	// module is the name of the declaring module, or "" for the root scope.
+	module string
+	pos    scanner.Position
+}
+
+// module is a loaded module.
+type module struct {
+	name string
+	// defs maps the name of each toplevel definition to its global name.
+	defs map[Symbol]Symbol
+}
+
+// moduleScope holds the names visible at the toplevel of a file.
+type moduleScope struct {
+	// name is the name of the module defined by the file. It is empty for the
+	// root scope, whose definitions are not qualified.
+	name string
+	// defs maps the name of each toplevel definition to its global name.
+	defs map[Symbol]Symbol
+	// imports holds the imported modules, by name.
+	imports map[string]*module
+	// unqualified maps an imported name to its global names. It has more than one
+	// global name if the name is imported from multiple modules.
+	unqualified map[Symbol][]Symbol
+}
+
+func newModuleScope(name string) *moduleScope {
+	return &moduleScope{
+		name:        name,
+		defs:        map[Symbol]Symbol{},
+		imports:     map[string]*module{},
+		unqualified: map[Symbol][]Symbol{},
+	}
+}
+
+func (s *moduleScope) clone() *moduleScope {
+	c := newModuleScope(s.name)
+	for sym, global := range s.defs {
+		c.defs[sym] = global
+	}
+	for name, m := range s.imports {
+		c.imports[name] = m
+	}
+	for sym, globals := range s.unqualified {
+		c.unqualified[sym] = append([]Symbol(nil), globals...)
+	}
+	return c
+}
+
+// NewModuleLoader creates a loader that searches the given directories for
+// modules.
+func NewModuleLoader(path ...string) *ModuleLoader {
+	return &ModuleLoader{
+		Path:      path,
+		modules:   map[string]*module{},
+		dataDecls: map[string]dataDecl{},
+		root:      newModuleScope(""),
+	}
+}
+
+// Clone returns a copy of the loader. Later Load calls on either one don't
+// affect the other, so a caller can keep a copy to undo a Load whose result
+// fails to compile.
+func (l *ModuleLoader) Clone() *ModuleLoader {
+	c := &ModuleLoader{
+		Path:      l.Path,
+		modules:   make(map[string]*module, len(l.modules)),
+		dataDecls: make(map[string]dataDecl, len(l.dataDecls)),
+		root:      l.root.clone(),
+	}
+	for name, m := range l.modules {
+		c.modules[name] = m
+	}
+	for name, d := range l.dataDecls {
+		c.dataDecls[name] = d
+	}
+	return c
+}
+
+// Load resolves the module and import declarations in nodes, which are
+// toplevel expressions returned by ParseFile, and loads the imported modules.
+// It returns the expressions to evaluate in order: the definitions of the
+// modules loaded for the first time, followed by nodes without the module and
+// import declarations. The references to imported definitions are rewritten to
+// their global names.
+//
+// The definitions in nodes are not qualified, even if nodes start with a module
+// declaration. The imports in nodes remain visible in later Load calls, as in a
+// REPL session.
+//
+// On error, it returns an ErrorList if a module cannot be parsed, and a
+// *ModuleError otherwise. The loader is then left unchanged, so the modules
+// loaded by the failed call are loaded again by the next one.
+func (l *ModuleLoader) Load(nodes []ASTNode) (result []ASTNode, err error) {
+	// Work on a copy, which replaces the loader only if the whole call succeeds.
+	c := l.Clone()
+	defer func() {
+		if r := recover(); r != nil {
+			switch e := r.(type) {
+			case *ModuleError:
+				err = e
+			case ErrorList:
+				err = e
+			default:
+				panic(r)
+			}
+		}
+	}()
+	result = c.loadFile(c.root, nodes)
+	*l = *c
+	return result, nil
+}
+
+func (l *ModuleLoader) errorf(pos scanner.Position, format string, args ...interface{}) {
+	panic(&ModuleError{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// loadFile resolves the toplevel expressions of a file in scope s. See Load for
+// the result.
+func (l *ModuleLoader) loadFile(s *moduleScope, nodes []ASTNode) []ASTNode {
+	var result []ASTNode
+	// The definitions and imports are visible to the whole file.
+	for i, node := range nodes {
+		switch v := node.(type) {
+		case *ASTModule:
+			if i != 0 {
+				l.errorf(v.pos, "module declaration must be at the start of the file")
+			}
+			if s.name != "" && v.Name != s.name {
+				l.errorf(v.pos, "file of module %s declares module %s", s.name, v.Name)
+			}
+		case *ASTImport:
+			result = append(result, l.importModule(s, v)...)
+		case *ASTAssign:
+			s.defs[v.Sym] = s.globalName(v.Sym)
+		case *ASTData:
+			l.declareData(s, v)
+		}
+	}
+	for _, node := range nodes {
+		switch v := node.(type) {
+		case *ASTModule, *ASTImport:
+			continue
+		case *ASTAssign:
+			l.resolve(s, nil, v.Expr)
+			v.Sym = s.defs[v.Sym]
+		default:
+			l.resolve(s, nil, v)
+		}
+		result = append(result, node)
+	}
+	return result
+}
+
+// declareData records the data type and the constructors declared by v in
+// scope s. They are shared by all modules, so it is an error if another module
+// has declared one of them.
+func (l *ModuleLoader) declareData(s *moduleScope, v *ASTData) {
+	declare := func(name string, pos scanner.Position) {
+		if d, ok := l.dataDecls[name]; ok && d.module != s.name {
+			l.errorf(pos, "%s is already declared at %v", name, d.pos)
+		}
+		l.dataDecls[name] = dataDecl{module: s.name, pos: pos}
+	}
+	declare("data type "+v.Sym.String(), v.pos)
+	for _, decl := range v.Cons {
+		declare("constructor "+decl.Sym.String(), decl.pos)
+	}
+}
+
+// globalName returns the name under which a toplevel definition is stored.
+func (s *moduleScope) globalName(sym Symbol) Symbol {
+	if s.name == "" {
+		return sym
+	}
+	return InternSymbol(s.name + "." + sym.String())
+}
+
+// importModule makes the names imported by v visible in s. It returns the
+// definitions of the modules loaded for the first time.
+func (l *ModuleLoader) importModule(s *moduleScope, v *ASTImport) []ASTNode {
+	m, result := l.loadModule(v.pos, v.Module)
+	s.imports[m.name] = m
+	names := v.Names
+	if names == nil {
+		for sym := range m.defs {
+			names = append(names, sym)
+		}
+	}
+	for _, sym := range names {
+		global, ok := m.defs[sym]
+		if !ok {
+			l.errorf(v.pos, "module %s does not define %v", m.name, sym)
+		}
+		if !symbolIn(global, s.unqualified[sym]) {
+			s.unqualified[sym] = append(s.unqualified[sym], global)
+		}
+	}
+	return result
+}
+
+// loadModule loads the named module unless it has been loaded already. It
+// returns the module and, if the module is loaded now, its definitions and
+// those of the modules it imports. Pos is the location of the import.
+func (l *ModuleLoader) loadModule(pos scanner.Position, name string) (*module, []ASTNode) {
+	if m, ok := l.modules[name]; ok {
+		return m, nil
+	}
+	for i, n := range l.loading {
+		if n == name {
+			cycle := append(append([]string{}, l.loading[i:]...), name)
+			l.errorf(pos, "import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	path := l.findModule(pos, name)
+	in, err := os.Open(path)
+	if err != nil {
+		l.errorf(pos, "import %s: %v", name, err)
+	}
+	defer in.Close()
+	nodes, err := ParseFile(path, in)
+	if err != nil {
+		panic(err)
+	}
+	l.loading = append(l.loading, name)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+	s := newModuleScope(name)
+	result := l.loadFile(s, nodes)
+	m := &module{name: name, defs: s.defs}
+	l.modules[name] = m
+	return m, result
+}
+
+// findModule returns the path of the file that defines the named module.
+func (l *ModuleLoader) findModule(pos scanner.Position, name string) string {
+	for _, dir := range l.Path {
+		path := filepath.Join(dir, name+".mfp")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	l.errorf(pos, "module %s not found in %v", name, l.Path)
+	return ""
+}
+
+// resolve rewrites the variable references in node to global names. Locals
+// are the local variables in scope.
+func (l *ModuleLoader) resolve(s *moduleScope, locals []Symbol, node ASTNode) {
+	switch v := node.(type) {
+	case *ASTVar:
+		v.Sym = l.resolveVar(s, locals, v)
+	case *ASTConst, *ASTCon:
+	case *ASTApply:
+		l.resolve(s, locals, v.Head)
+		l.resolve(s, locals, v.Tail)
+	case *ASTLambda:
+		l.resolve(s, append(locals, v.Arg), v.Body)
+	case *ASTApplyLeafFunction:
+		for _, arg := range v.Args {
+			l.resolve(s, locals, arg)
+		}
+	case *ASTLetrec:
+		for _, b := range v.Bindings {
+			locals = append(locals, b.Sym)
+		}
+		for _, b := range v.Bindings {
+			l.resolve(s, locals, b.Expr)
+		}
+		l.resolve(s, locals, v.Body)
+	case *ASTIf:
+		l.resolve(s, locals, v.Cond)
+		l.resolve(s, locals, v.Then)
+		l.resolve(s, locals, v.Else)
+	case *ASTCase:
+		l.resolve(s, locals, v.Expr)
+		for _, alt := range v.Alts {
+			l.resolve(s, patternVars(alt.Pat, locals), alt.Body)
+		}
+	case *ASTAssign:
+		l.resolve(s, locals, v.Expr)
+	}
+}
+
+// resolveVar returns the global name of the variable, or its name if it is
+// local, or it is not defined by a module, e.g., a prelude function.
+func (l *ModuleLoader) resolveVar(s *moduleScope, locals []Symbol, v *ASTVar) Symbol {
+	for i := len(locals) - 1; i >= 0; i-- {
+		if locals[i] == v.Sym {
+			return v.Sym
+		}
+	}
+	name := v.Sym.String()
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		modName, sym := name[:i], InternSymbol(name[i+1:])
+		defs := s.defs
+		if modName != s.name {
+			m, ok := s.imports[modName]
+			if !ok {
+				l.errorf(v.pos, "module %s is not imported", modName)
+			}
+			defs = m.defs
+		}
+		global, ok := defs[sym]
+		if !ok {
+			l.errorf(v.pos, "module %s does not define %v", modName, sym)
+		}
+		return global
+	}
+	if global, ok := s.defs[v.Sym]; ok {
+		return global
+	}
+	switch globals := s.unqualified[v.Sym]; len(globals) {
+	case 0:
+		return v.Sym
+	case 1:
+		return globals[0]
+	default:
+		names := make([]string, len(globals))
+		for i, g := range globals {
+			names[i] = g.String()
+		}
+		sort.Strings(names)
+		l.errorf(v.pos, "%v is ambiguous: it may refer to %s", v.Sym, strings.Join(names, " or "))
+	}
+	panic("not reached")
+}
@@ -0,0 +1,185 @@
+package minifp_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+// writeModules creates a directory that holds the given files, and returns its
+// path.
+func writeModules(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "modules")
+	expect.NoError(t, err)
+	for name, src := range files {
+		expect.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0600))
+	}
+	return dir
+}
+
+// load resolves src with the loader, then type-checks and evaluates the
+// result. It returns the value of the last expression.
+func load(t *testing.T, l *minifp.ModuleLoader, km *minifp.KMachine, tc *minifp.TypeChecker, src string) (string, error) {
+	nodes, err := l.Load(minifp.Parse(strings.NewReader(src)))
+	if err != nil {
+		return "", err
+	}
+	var val minifp.Literal
+	for _, node := range nodes {
+		if _, err := tc.Check(node); err != nil {
+			return "", err
+		}
+		if val, err = km.RunErr(km.Compile(node)); err != nil {
+			return "", err
+		}
+	}
+	return val.String(), nil
+}
+
+func TestModules(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"Util.mfp": `module Util;
+double x = x * 2;
+// A module definition may shadow a prelude function.
+length xs = 100 + foldr (\_ n -> n + 1) 0 xs;
+twice f x = f (f x)`,
+		"Geom.mfp": `module Geom;
+import Util (double);
+data Shape = Square Int | Rect Int Int;
+area s = case s of {Square n -> n * n; Rect w h -> w * h};
+perimeter s = case s of {Square n -> double (2 * n); Rect w h -> double (w + h)};
+scaled s = Util.twice double (area s)`,
+	})
+	defer os.RemoveAll(dir)
+	l := minifp.NewModuleLoader(filepath.Join(dir, "nonexistent"), dir)
+	km := minifp.NewMachine()
+	tc := minifp.NewTypeChecker()
+	for _, test := range []struct{ src, want string }{
+		{`import Geom; area (Rect 2 3)`, "6"},
+		{`perimeter (Square 3)`, "12"},
+		{`Geom.scaled (Square 2)`, "16"},
+		// Util is loaded, but it is not imported by the root.
+		{`length [1, 2]`, "2"},
+		{`import Util (twice); twice (\x -> x + 1) 0`, "2"},
+		{`Util.length [1, 2]`, "102"},
+		{`double x = x + 1000; double 1`, "1001"},
+		{`Util.double 1`, "2"},
+		// A local variable shadows an imported name.
+		{`shadow = \area -> area + 1; shadow 1`, "2"},
+	} {
+		got, err := load(t, l, km, tc, test.src)
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, got, test.want, test.src)
+	}
+
+	// Each module is compiled once.
+	nodes, err := l.Load(minifp.Parse(strings.NewReader(`import Util; import Geom; 1`)))
+	expect.NoError(t, err)
+	expect.EQ(t, len(nodes), 1)
+
+	typ, err := tc.Check(minifp.Parse(strings.NewReader(`Geom.area`))[0])
+	expect.NoError(t, err)
+	expect.EQ(t, typ.String(), "Shape -> Int")
+}
+
+func TestModuleErrors(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"A.mfp":     "module A; import B; a = 1",
+		"B.mfp":     "module B; import C; b = 1",
+		"C.mfp":     "module C; import A; c = 1",
+		"Bad.mfp":   "module Bad; x = (1 +",
+		"Wrong.mfp": "module Right; x = 1",
+		"Late.mfp":  "x = 1; module Late",
+		"X.mfp":     "module X; f = 1; g = 2",
+		"Y.mfp":     "module Y; f = 10",
+		"D1.mfp":    "module D1; data T = Mk Int",
+		"D2.mfp":    "module D2; data T = Other",
+		"D3.mfp":    "module D3; data U = Other | Mk",
+	})
+	defer os.RemoveAll(dir)
+	for _, test := range []struct{ src, want string }{
+		{`import A`, "C.mfp:1:11: import cycle: A -> B -> C -> A"},
+		{`import Nope`, "<input>:1:1: module Nope not found in"},
+		{`import Bad`, "Bad.mfp:1:21: syntax error: unexpected EOF"},
+		{`import Wrong`, "Wrong.mfp:1:1: file of module Wrong declares module Right"},
+		{`import Late`, "Late.mfp:1:8: module declaration must be at the start of the file"},
+		{`import X (h)`, "<input>:1:1: module X does not define h"},
+		{`X.f`, "<input>:1:1: module X is not imported"},
+		{`import X; X.h`, "<input>:1:11: module X does not define h"},
+		{`import X; import Y; f`, "<input>:1:21: f is ambiguous: it may refer to X.f or Y.f"},
+		{`import D1; import D2`, "D2.mfp:1:12: data type T is already declared at " + filepath.Join(dir, "D1.mfp") + ":1:12"},
+		{`import D1; import D3`, "D3.mfp:1:29: constructor Mk is already declared at"},
+		{`data V = Mk; import D1`, "D1.mfp:1:21: constructor Mk is already declared at <input>:1:10"},
+	} {
+		_, err := minifp.NewModuleLoader(dir).Load(minifp.Parse(strings.NewReader(test.src)))
+		expect.True(t, err != nil, test.src)
+		if err != nil {
+			expect.HasSubstr(t, err.Error(), test.want, test.src)
+		}
+	}
+
+	_, err := minifp.NewModuleLoader(dir).Load(minifp.Parse(strings.NewReader(`import Bad`)))
+	var errs minifp.ErrorList
+	expect.True(t, errors.As(err, &errs), err)
+
+	// A name imported from two modules is fine if it is not used, or if it is
+	// qualified.
+	got, err := load(t, minifp.NewModuleLoader(dir), minifp.NewMachine(), minifp.NewTypeChecker(),
+		`import X; import Y; X.f + Y.f + g`)
+	expect.NoError(t, err)
+	expect.EQ(t, got, "13")
+
+	// A failed Load leaves the loader unchanged, so the modules it loaded are
+	// loaded again.
+	l := minifp.NewModuleLoader(dir)
+	_, err = l.Load(minifp.Parse(strings.NewReader(`import X; import Nope`)))
+	expect.HasSubstr(t, err.Error(), "module Nope not found")
+	got, err = load(t, l, minifp.NewMachine(), minifp.NewTypeChecker(), `import X; X.f + g`)
+	expect.NoError(t, err)
+	expect.EQ(t, got, "3")
+
+	// The root scope may redeclare its own data types.
+	got, err = load(t, l, minifp.NewMachine(), minifp.NewTypeChecker(), `data V = V1; data V = V2; V2`)
+	expect.NoError(t, err)
+	expect.EQ(t, got, "V2")
+
+	// Module declarations must be resolved before evaluation.
+	x := minifp.Parse(strings.NewReader(`import X`))
+	_, err = minifp.NewTypeChecker().Check(x[0])
+	expect.HasSubstr(t, err.Error(), "import X must be resolved by a ModuleLoader")
+	_, err = minifp.NewMachine().CompileErr(x[0])
+	expect.HasSubstr(t, err.Error(), "import X must be resolved by a ModuleLoader")
+}
+
+func TestParseModule(t *testing.T) {
+	nodes := minifp.Parse(strings.NewReader(`module Foo; import Bar; import Baz (x, y); import Qux (); Bar.f x`))
+	var got []string
+	for _, node := range nodes {
+		got = append(got, node.String())
+	}
+	expect.EQ(t, got, []string{"module Foo", "import Bar", "import Baz (x, y)", "import Qux ()", "(Bar.f x)"})
+
+	_, err := minifp.ParseErr(strings.NewReader(`Bar.Baz`))
+	expect.HasSubstr(t, err.Error(), "invalid qualified name Bar.Baz")
+
+	// A definition can't bind a qualified name.
+	for _, test := range []struct{ src, want string }{
+		{`a A.a0=0`, "<input>:1:3: parameter A.a0 cannot be a qualified name"},
+		{`f M.x = M.x`, "<input>:1:3: parameter M.x cannot be a qualified name"},
+		{`M.f x = x`, "<input>:1:1: cannot define qualified name M.f"},
+		{`letrec M.x = 1 in M.x`, "<input>:1:8: cannot define qualified name M.x"},
+		{`letrec f M.x = 1 in f 2`, "<input>:1:10: parameter M.x cannot be a qualified name"},
+	} {
+		_, err := minifp.ParseErr(strings.NewReader(test.src))
+		expect.True(t, err != nil, test.src)
+		if err != nil {
+			expect.HasSubstr(t, err.Error(), test.want, test.src)
+		}
+	}
+}
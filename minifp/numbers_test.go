@@ -0,0 +1,163 @@
+package minifp_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+func TestFloat(t *testing.T) {
+	km := minifp.NewMachine()
+	for _, test := range []struct{ src, want string }{
+		{`1.5`, "1.5"},
+		{`2.0`, "2.0"},
+		{`1e3`, "1000.0"},
+		{`1.5 + 2.25`, "3.75"},
+		{`1 + 2.5`, "3.5"},
+		{`2.5 * 2`, "5.0"},
+		{`10 - 0.5`, "9.5"},
+		{`7 / 2`, "3"},
+		{`7 / 2.0`, "3.5"},
+		{`7.0 / 2`, "3.5"},
+		{`1 / 0.0`, "+Inf"},
+		{`1 == 1.0`, "true"},
+		{`1.5 < 2`, "true"},
+		{`case 2.0 of {2 -> 1; _ -> 0}`, "1"},
+		{`sqrt 2.25`, "1.5"},
+		{`sqrt 16`, "4.0"},
+		{`floor 2.7`, "2"},
+		{`floor (0.0 - 2.5)`, "-3"},
+		{`ceil 2.1`, "3"},
+		{`truncate (0.0 - 2.7)`, "-2"},
+		{`toFloat 3`, "3.0"},
+		{`toFloat (strlen "ab") / 4`, "0.5"},
+		{`show 0.25`, `"0.25"`},
+		{`(\x -> x * 2) 1.5`, "3.0"},
+		{`foldl (\acc x -> acc + x) 0 [0.5, 1.5, 2]`, "4.0"},
+	} {
+		expect.EQ(t, run(t, km, test.src).String(), test.want, test.src)
+	}
+
+	x := minifp.Parse(strings.NewReader(`floor (sqrt (0.0 - 1.0))`))
+	_, err := km.RunErr(km.Compile(x[0]))
+	expect.HasSubstr(t, err.Error(), "cannot convert NaN to an int")
+
+	x = minifp.Parse(strings.NewReader(`1.5 + (1 == 1)`))
+	_, err = km.RunErr(km.Compile(x[0]))
+	expect.HasSubstr(t, err.Error(), "expect a float, but found true")
+}
+
+func TestFloatTypes(t *testing.T) {
+	for _, test := range []struct{ src, want string }{
+		{`1.5`, "Float"},
+		{`1 + 2.5`, "Float"},
+		{`2.5 + 1`, "Float"},
+		{`1 + 2`, "Int"},
+		{`\x -> x + 1`, "Int -> Int"},
+		{`\x -> x + 1.0`, "Float -> Float"},
+		{`\x y -> x / y`, "Int -> Int -> Int"},
+		{`(\x -> x * 2) 1.5`, "Float"},
+		{`sqrt 2`, "Float"},
+		{`floor`, "Float -> Int"},
+		{`toFloat`, "Int -> Float"},
+		{`[1, 2.5]`, "[Float]"},
+		{`\x -> if (x == 1) 2.5 x`, "Float -> Float"},
+		// Numeric types are generalized, and default to Int only in the type of a
+		// toplevel expression.
+		{`double x = x * 2`, "num -> num"},
+		{`double x = x * 2; double`, "Int -> Int"},
+		{`double x = x * 2; double 1.5`, "Float"},
+		{`double x = x * 2; Pair (double 1) (double 1.5)`, "Pair Int Float"},
+		{`letrec d = \x -> x + x in Pair (d 1) (d 1.5)`, "Pair Int Float"},
+		{`f x y = Pair (x + 1) (y * 2)`, "num -> num1 -> Pair num num1"},
+		{`f x y = Pair (x + 1) y`, "num -> a -> Pair num a"},
+	} {
+		got, err := typeOf(t, test.src)
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, got, test.want, test.src)
+	}
+	for _, test := range []struct{ src, want string }{
+		{`toFloat 1.5`, "type mismatch: expect Int, but found Float"},
+		{`strlen "a" + 1.5`, "type mismatch: expect Int, but found Float"},
+		{`(1 == 1) + 1`, "type mismatch: expect Int, but found Bool"},
+		{`"a" * 2`, "type mismatch: expect Int, but found String"},
+		{`double x = x * 2; double "a"`, "type mismatch: expect Int, but found String"},
+	} {
+		_, err := typeOf(t, test.src)
+		expect.True(t, err != nil, test.src)
+		if err != nil {
+			expect.HasSubstr(t, err.Error(), test.want, test.src)
+		}
+	}
+}
+
+func TestIntDivision(t *testing.T) {
+	km := minifp.NewMachine()
+	for _, test := range []struct{ src, want string }{
+		{`-5`, "-5"},
+		{`- 5 + 2`, "-3"},
+		{`-(2 + 3)`, "-5"},
+		{`3 - -2`, "5"},
+		{`2 * -3`, "-6"},
+		{`-1.5`, "-1.5"},
+		{`-strlen "abc"`, "-3"},
+		{`(\x -> -x) 4`, "-4"},
+		{`-9223372036854775808`, "-9223372036854775808"},
+		{`-(-9223372036854775808)`, "9223372036854775808"},
+		{`map negate [1, -2]`, "[-1, 2]"},
+		{`case 0 - 1 of {-1 -> "neg"; _ -> "other"}`, `"neg"`},
+		// "/" and "%" truncate toward zero.
+		{`10 / 3`, "3"},
+		{`-7 / 2`, "-3"},
+		{`7 / -2`, "-3"},
+		{`10 % 3`, "1"},
+		{`-7 % 2`, "-1"},
+		{`7 % -2`, "1"},
+		{`7.5 % 2`, "1.5"},
+		{`2 + 10 % 4 * 3`, "8"},
+		// div and mod round toward negative infinity.
+		{`div 7 2`, "3"},
+		{`div (-7) 2`, "-4"},
+		{`div 7 (-2)`, "-4"},
+		{`div (-7) (-2)`, "3"},
+		{`mod 7 2`, "1"},
+		{`mod (-7) 2`, "1"},
+		{`mod 7 (-2)`, "-1"},
+		{`mod (-7) (-2)`, "-1"},
+		{`mod 6 (-3)`, "0"},
+		{`div (-9223372036854775808) (-1)`, "9223372036854775808"},
+		{`mod (-9223372036854775808) (-1)`, "0"},
+		{`div (-99999999999999999999) 2`, "-50000000000000000000"},
+		{`mod (-99999999999999999999) 2`, "1"},
+		{`mod 99999999999999999999 (-7)`, "-6"},
+		{`-99999999999999999999 % 7`, "-1"},
+		{`1 / 0.0`, "+Inf"},
+	} {
+		expect.EQ(t, run(t, km, test.src).String(), test.want, test.src)
+	}
+
+	for _, src := range []string{`1 / 0`, `1 % 0`, `div 1 0`, `mod 1 0`, `99999999999999999999 / 0`} {
+		x := minifp.Parse(strings.NewReader(src))
+		_, err := km.RunErr(km.Compile(x[0]))
+		expect.True(t, errors.Is(err, minifp.ErrDivisionByZero), src, err)
+	}
+
+	for _, test := range []struct{ src, want string }{
+		{`\x -> -x`, "Int -> Int"},
+		{`-1.5`, "Float"},
+		{`\x y -> x % y`, "Int -> Int -> Int"},
+		{`div`, "Int -> Int -> Int"},
+	} {
+		got, err := typeOf(t, test.src)
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, got, test.want, test.src)
+	}
+	_, err := typeOf(t, `mod 1.5 2`)
+	expect.HasSubstr(t, err.Error(), "type mismatch: expect Int, but found Float")
+
+	_, err = minifp.ParseErr(strings.NewReader(`case "a" of {-"a" -> 1}`))
+	expect.HasSubstr(t, err.Error(), `invalid pattern -"a"`)
+}
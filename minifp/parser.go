@@ -1,25 +1,118 @@
 package minifp
 
 //go:generate goyacc -l -o parser_generated.go parser.y
+//go:generate rm -f y.output
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
 	"strconv"
+	"strings"
 	"text/scanner"
+	"unicode"
+	"unicode/utf8"
 )
 
+// SyntaxError describes a lexical or grammatical error found by ParseErr.
+type SyntaxError struct {
+	Pos scanner.Position
+	// Token is the text of the offending token. It is empty at end of input.
+	Token string
+	// Expected lists the tokens that the grammar would have accepted in place of
+	// Token. It is empty if the set is unknown or too large to be useful.
+	Expected []string
+	Msg      string
+}
+
+func (e *SyntaxError) Error() string {
+	var buf strings.Builder
+	buf.WriteString(e.Pos.String())
+	buf.WriteString(": ")
+	buf.WriteString(e.Msg)
+	if len(e.Expected) > 0 {
+		buf.WriteString(", expecting ")
+		buf.WriteString(strings.Join(e.Expected, " or "))
+	}
+	return buf.String()
+}
+
+// ErrorList is the list of errors reported by a single ParseErr call, in
+// source order.
+type ErrorList []*SyntaxError
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// As allows errors.As to find the individual *SyntaxErrors.
+func (l ErrorList) As(target interface{}) bool {
+	for _, e := range l {
+		if errors.As(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is reports whether any of the errors is target.
+func (l ErrorList) Is(target error) bool {
+	for _, e := range l {
+		if errors.Is(e, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse is similar to ParseErr, but it panics on error.
 func Parse(in io.Reader) []ASTNode {
+	nodes, err := ParseErr(in)
+	if err != nil {
+		panic(err)
+	}
+	return nodes
+}
+
+// ParseErr parses a sequence of toplevel expressions. On error, it returns an
+// ErrorList. The parser recovers at ';' and ')' so that one call can report
+// multiple errors.
+func ParseErr(in io.Reader) ([]ASTNode, error) {
+	return ParseFile("", in)
+}
+
+// ParseFile is similar to ParseErr, but the positions in the result and in the
+// errors refer to the given file name.
+func ParseFile(filename string, in io.Reader) ([]ASTNode, error) {
 	p := parser{
 		sc:  &scanner.Scanner{},
 		ops: map[byte]*opTrieNode{},
 	}
 	p.addOp("-", '-')
 	p.addOp("+", '+')
+	p.addOp("++", tokConcat)
 	p.addOp("*", '*')
+	p.addOp("/", '/')
+	p.addOp("%", '%')
 	p.addOp("(", '(')
 	p.addOp(")", ')')
 	p.addOp(";", ';')
+	p.addOp("{", '{')
+	p.addOp("}", '}')
+	p.addOp("|", '|')
+	p.addOp("||", tokOr)
+	p.addOp("&&", tokAnd)
+	p.addOp("[", '[')
+	p.addOp("]", ']')
+	p.addOp(",", ',')
+	p.addOp(":", ':')
 	p.addOp("\\", '\\')
 	p.addOp("=", '=')
 	p.addOp("==", tokEQ)
@@ -29,19 +122,27 @@ func Parse(in io.Reader) []ASTNode {
 	p.addOp("<", '<')
 	p.addOp(">=", tokGE)
 	p.addOp("<=", tokLE)
-	p.sc.Error = func(_ *scanner.Scanner, msg string) {
-		if p.err == nil {
-			p.err = errors.New(msg)
+	p.sc.Init(in)
+	p.sc.Mode = scanner.GoTokens
+	p.sc.Filename = filename
+	// Init resets Error, so it must be set afterwards.
+	p.sc.Error = func(sc *scanner.Scanner, msg string) {
+		pos := sc.Position
+		if !pos.IsValid() {
+			pos = sc.Pos()
 		}
+		p.errors = append(p.errors, &SyntaxError{Pos: pos, Token: sc.TokenText(), Msg: msg})
 	}
-	p.sc.Mode = scanner.GoTokens
-	p.sc.Init(in)
 
 	yyParse(&p)
-	if p.err != nil {
-		panic(p.err)
+	if len(p.errors) > 0 {
+		return nil, p.errors
 	}
-	return p.result
+	return p.result, nil
+}
+
+func init() {
+	yyErrorVerbose = true
 }
 
 type opTrieNode struct {
@@ -50,10 +151,14 @@ type opTrieNode struct {
 }
 
 type parser struct {
-	err    error
+	errors ErrorList
 	ops    map[byte]*opTrieNode
 	result []ASTNode
 	sc     *scanner.Scanner
+
+	// Location and text of the token most recently returned by Lex.
+	tokPos  scanner.Position
+	tokText string
 }
 
 func (p *parser) addOp(op string, tok int) {
@@ -76,87 +181,281 @@ func (p *parser) addOp(op string, tok int) {
 	panic(op)
 }
 
-// Lex implements yyLexer
+func (p *parser) errorf(pos scanner.Position, token string, format string, args ...interface{}) {
+	p.errors = append(p.errors, &SyntaxError{Pos: pos, Token: token, Msg: fmt.Sprintf(format, args...)})
+}
+
+// tokDisplayNames maps goyacc token names to the names shown in SyntaxError.
+var tokDisplayNames = map[string]string{
+	"$end":         "EOF",
+	"tokIdent":     "identifier",
+	"tokConIdent":  "constructor",
+	"tokQualIdent": "qualified name",
+	"tokModule":    "module",
+	"tokImport":    "import",
+	"tokData":      "data",
+	"tokCase":      "case",
+	"tokOf":        "of",
+	"tokLiteral":   "literal",
+	"tokLetrec":    "letrec",
+	"tokIn":        "in",
+	"tokIf":        "if",
+	"tokArrow":     "->",
+	"tokEQ":        "==",
+	"tokNEQ":       "!=",
+	"tokGE":        ">=",
+	"tokLE":        "<=",
+	"tokConcat":    "++",
+	"tokAnd":       "&&",
+	"tokOr":        "||",
+	`'\\'`:         `\`,
+}
+
+func tokDisplayName(name string) string {
+	if n, ok := tokDisplayNames[name]; ok {
+		return n
+	}
+	if len(name) >= 3 && name[0] == '\'' && name[len(name)-1] == '\'' {
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+// Error implements yyLexer. Msg is a goyacc verbose message of the form
+// "syntax error: unexpected X[, expecting Y or Z]".
 func (p *parser) Error(msg string) {
-	if p.err == nil {
-		p.err = errors.New(msg)
+	e := &SyntaxError{Pos: p.tokPos, Token: p.tokText, Msg: msg}
+	const unexpected = "syntax error: unexpected "
+	if strings.HasPrefix(msg, unexpected) {
+		rest := msg[len(unexpected):]
+		if i := strings.Index(rest, ", expecting "); i >= 0 {
+			for _, tok := range strings.Split(rest[i+len(", expecting "):], " or ") {
+				e.Expected = append(e.Expected, tokDisplayName(tok))
+			}
+			rest = rest[:i]
+		}
+		e.Msg = "syntax error: unexpected " + tokDisplayName(rest)
 	}
+	p.errors = append(p.errors, e)
 }
 
 // Lex implements yyLexer
 func (p *parser) Lex(y *yySymType) int {
-	if p.err != nil {
-		return 0
-	}
-	ch := p.sc.Scan()
-	if p.err != nil {
-		return 0
-	}
-	if ch == scanner.EOF {
-		return 0
-	}
-	if ch == scanner.Int {
-		val, err := strconv.ParseInt(p.sc.TokenText(), 0, 64)
-		if err != nil {
-			panicf(p.sc.Pos(), "parse int %s: %s", p.sc.TokenText(), err)
+	for {
+		ch := p.sc.Scan()
+		p.tokPos = p.sc.Position
+		p.tokText = p.sc.TokenText()
+		if ch == scanner.EOF {
+			p.tokPos = p.sc.Pos()
+			p.tokText = ""
+			y.pos = p.tokPos
+			return 0
 		}
-		y.ast = &ASTConst{Val: Literal{typ: LiteralInt, intVal: val}}
-		return tokLiteral
-	}
-	if ch == scanner.Ident {
-		y.ident = p.sc.TokenText()
-		switch p.sc.TokenText() {
-		case "letrec":
-			return tokLetrec
-		case "in":
-			return tokIn
-		case "if":
-			return tokIf
-		default:
-			return tokIdent
+		y.pos = p.tokPos
+		if ch == scanner.Int {
+			val, err := strconv.ParseInt(p.tokText, 0, 64)
+			if err == nil {
+				y.ast = &ASTConst{pos: p.tokPos, Val: NewLiteralInt(val)}
+				return tokLiteral
+			}
+			// The literal may be too large for int64.
+			bigVal, ok := new(big.Int).SetString(p.tokText, 0)
+			if !ok {
+				p.errorf(p.tokPos, p.tokText, "parse int %s: %s", p.tokText, err)
+				bigVal = new(big.Int)
+			}
+			y.ast = &ASTConst{pos: p.tokPos, Val: newLiteralBig(bigVal)}
+			return tokLiteral
 		}
-	}
-	if e, ok := p.ops[byte(ch)]; ok {
-		if e.ch2 != nil {
-			ch2 := p.sc.Peek()
-			if tok, ok := e.ch2[ch2]; ok {
-				p.sc.Next()
-				return tok
+		if ch == scanner.Float {
+			val, err := strconv.ParseFloat(p.tokText, 64)
+			if err != nil {
+				p.errorf(p.tokPos, p.tokText, "parse float %s: %s", p.tokText, err)
+			}
+			y.ast = &ASTConst{pos: p.tokPos, Val: NewLiteralFloat(val)}
+			return tokLiteral
+		}
+		if ch == scanner.String || ch == scanner.RawString {
+			val, err := strconv.Unquote(p.tokText)
+			if err != nil {
+				p.errorf(p.tokPos, p.tokText, "invalid string literal %s", p.tokText)
+			}
+			y.ast = &ASTConst{pos: p.tokPos, Val: NewLiteralString(val)}
+			return tokLiteral
+		}
+		if ch == scanner.Char {
+			val, err := strconv.Unquote(p.tokText)
+			if err != nil || utf8.RuneCountInString(val) != 1 {
+				p.errorf(p.tokPos, p.tokText, "invalid char literal %s", p.tokText)
 			}
+			r, _ := utf8.DecodeRuneInString(val)
+			y.ast = &ASTConst{pos: p.tokPos, Val: NewLiteralChar(r)}
+			return tokLiteral
 		}
-		if e.tok == 0 {
-			panicf(p.sc.Pos(), "invalid char '%c'", ch)
+		if ch == scanner.Ident {
+			y.ident = p.tokText
+			switch p.tokText {
+			case "letrec":
+				return tokLetrec
+			case "in":
+				return tokIn
+			case "if":
+				return tokIf
+			case "data":
+				return tokData
+			case "case":
+				return tokCase
+			case "of":
+				return tokOf
+			case "module":
+				return tokModule
+			case "import":
+				return tokImport
+			}
+			if unicode.IsUpper([]rune(p.tokText)[0]) {
+				if p.sc.Peek() == '.' {
+					return p.lexQualIdent(y)
+				}
+				return tokConIdent
+			}
+			return tokIdent
+		}
+		if e, ok := p.ops[byte(ch)]; ok && ch < 0x80 {
+			if e.ch2 != nil {
+				ch2 := p.sc.Peek()
+				if tok, ok := e.ch2[ch2]; ok {
+					p.sc.Next()
+					p.tokText += string(ch2)
+					return tok
+				}
+			}
+			if e.tok != 0 {
+				return e.tok
+			}
 		}
-		return e.tok
-	}
-	// if ch == '-' {
-	// 	ch2 := p.sc.Peek()
-	// 	if ch2 == '>' {
-	// 		p.sc.Next()
-	// 		return tokArrow
-	// 	}
-	// 	return int(ch)
-	// }
-	// if ch == '>' {
-	// 	ch2 := p.sc.Peek()
-	// 	if ch2 == '=' {
-	// 		p.sc.Next()
-	// 		return tokGE
-	// 	}
-	// 	return int(ch)
-	// }
-	// if ch == '=' || ch == '(' || ch == ')' || ch == '+' ||
-	// 	ch == '*' || ch == '\\' || ch == ';' {
-	// 	return int(ch)
-	// }
-	panicf(p.sc.Pos(), "invalid char '%c'", ch)
-	return 1
+		// Report the bad token and keep going, so that the parser can find more
+		// errors.
+		p.errorf(p.tokPos, p.tokText, "invalid token %q", p.tokText)
+	}
+}
+
+// lexQualIdent reads a qualified name, e.g., "Foo.bar". The module name has
+// been read already.
+func (p *parser) lexQualIdent(y *yySymType) int {
+	p.sc.Next()
+	if ch := p.sc.Scan(); ch != scanner.Ident || !isIdent(p.sc.TokenText()) {
+		p.errorf(p.tokPos, p.tokText, "invalid qualified name %s.%s", p.tokText, p.sc.TokenText())
+	}
+	p.tokText += "." + p.sc.TokenText()
+	y.ident = p.tokText
+	return tokQualIdent
 }
 
 func newLambda(pos scanner.Position, args []string, expr ASTNode) ASTNode {
 	arg := InternSymbol(args[0])
 	if len(args) == 1 {
-		return &ASTLambda{Arg: arg, Body: expr}
+		return &ASTLambda{pos: pos, Arg: arg, Body: expr}
 	}
 	return &ASTLambda{pos: pos, Arg: arg, Body: newLambda(pos, args[1:], expr)}
 }
+
+// newAssign creates an assignment "lhs = rhs". Lhs must be a variable,
+// optionally applied to parameters as in "f x y = x + y". Neither the variable
+// nor the parameters may be qualified names.
+func newAssign(yylex yyLexer, lhs, rhs ASTNode) *ASTAssign {
+	var args []string
+	for node := lhs; node != nil; {
+		switch v := node.(type) {
+		case *ASTVar:
+			if isQualified(v.Sym) {
+				yylex.(*parser).errorf(v.pos, "", "cannot define qualified name %v", v.Sym)
+				return nil
+			}
+			if len(args) > 0 {
+				rhs = newLambda(v.pos, args, rhs)
+			}
+			return &ASTAssign{pos: v.pos, Sym: v.Sym, Expr: rhs}
+		case *ASTApply:
+			if arg, ok := v.Tail.(*ASTVar); ok {
+				if isQualified(arg.Sym) {
+					yylex.(*parser).errorf(arg.pos, "", "parameter %v cannot be a qualified name", arg.Sym)
+					return nil
+				}
+				args = append([]string{arg.Sym.String()}, args...)
+				node = v.Head
+				continue
+			}
+		}
+		yylex.(*parser).errorf(lhs.Pos(), "", "invalid left-hand side of '=': %v", lhs)
+		break
+	}
+	return nil
+}
+
+// isQualified checks if sym is a qualified name, e.g., "Foo.bar".
+func isQualified(sym Symbol) bool {
+	return strings.ContainsRune(sym.String(), '.')
+}
+
+func newData(pos scanner.Position, name string, params []string, cons []*ASTConDecl) *ASTData {
+	n := &ASTData{pos: pos, Sym: InternSymbol(name), Cons: cons}
+	for _, p := range params {
+		n.Params = append(n.Params, InternSymbol(p))
+	}
+	return n
+}
+
+// newNegate creates "-expr". The negation of a number literal is a literal.
+func newNegate(pos scanner.Position, expr ASTNode) ASTNode {
+	if c, ok := expr.(*ASTConst); ok && isNumber(c.Val) {
+		return &ASTConst{pos: pos, Val: negateLiteral(c.Val)}
+	}
+	return &ASTApplyLeafFunction{pos: pos, Op: funcs["negate"], Args: []ASTNode{expr}}
+}
+
+// newNegativePattern creates a pattern "-lit". Lit must be a number.
+func newNegativePattern(yylex yyLexer, pos scanner.Position, lit Literal) ASTPattern {
+	if !isNumber(lit) {
+		yylex.(*parser).errorf(pos, "", "invalid pattern -%v", lit)
+		return &ASTPatLiteral{pos: pos, Val: lit}
+	}
+	return &ASTPatLiteral{pos: pos, Val: negateLiteral(lit)}
+}
+
+// newCons creates "head : tail". OpPos is the location of the ':' token.
+func newCons(pos, opPos scanner.Position, head, tail ASTNode) ASTNode {
+	con := &ASTCon{pos: opPos, Sym: consSpec.sym}
+	return &ASTApply{pos: pos, Head: &ASTApply{pos: pos, Head: con, Tail: head}, Tail: tail}
+}
+
+// newList creates "[e0, e1, ...]", which is "e0 : e1 : ... : []".
+// newBool creates an expression that evaluates to the given boolean. The
+// language has no boolean literals, so it compares two integers, like the
+// prelude's true and false.
+func newBool(pos scanner.Position, b bool) ASTNode {
+	rhs := int64(1)
+	if b {
+		rhs = 0
+	}
+	return &ASTApplyLeafFunction{pos: pos, Op: funcs["builtin:=="], Args: []ASTNode{
+		&ASTConst{pos: pos, Val: NewLiteralInt(0)},
+		&ASTConst{pos: pos, Val: NewLiteralInt(rhs)}}}
+}
+
+func newList(pos scanner.Position, elems []ASTNode) ASTNode {
+	var list ASTNode = &ASTCon{pos: pos, Sym: nilSpec.sym}
+	for i := len(elems) - 1; i >= 0; i-- {
+		list = newCons(elems[i].Pos(), pos, elems[i], list)
+	}
+	return list
+}
+
+// newListPattern creates a pattern "[p0, p1, ...]", which is "p0 : p1 : ... :
+// []".
+func newListPattern(pos scanner.Position, elems []ASTPattern) ASTPattern {
+	var list ASTPattern = &ASTPatCon{pos: pos, Sym: nilSpec.sym}
+	for i := len(elems) - 1; i >= 0; i-- {
+		list = &ASTPatCon{pos: elems[i].Pos(), Sym: consSpec.sym, Args: []ASTPattern{elems[i], list}}
+	}
+	return list
+}
@@ -5,37 +5,62 @@ import (
         "text/scanner"
         )
 
-func lexpos(yylex yyLexer) scanner.Position {
-  return yylex.(*parser).sc.Pos()
-}
-
 %}
 
 %union {
+  // pos is the start of the token or the nonterminal. Lex sets it for
+  // tokens; goyacc copies it from the leftmost symbol for nonterminals.
+  pos scanner.Position
   astlist []ASTNode
   ast ASTNode
   assign *ASTAssign
   assignlist []*ASTAssign
   arglist []string
   ident string
+  typ Type
+  types []Type
+  conDecl *ASTConDecl
+  conDecls []*ASTConDecl
+  pat ASTPattern
+  pats []ASTPattern
+  alt ASTCaseAlt
+  alts []ASTCaseAlt
+  syms []Symbol
 }
 
 %start main
 
-%token <ident> tokIdent
-%token <ident> tokLetrec tokIn tokIf
+%token <ident> tokIdent tokConIdent tokQualIdent
+%token <ident> tokLetrec tokIn tokIf tokData tokCase tokOf tokModule tokImport
 %token <ast> tokLiteral
-%token <ident> tokArrow tokEQ tokNEQ tokGE tokLE
+%token <ident> tokArrow tokEQ tokNEQ tokGE tokLE tokConcat tokAnd tokOr
 
 %type<astlist> main toplevelExprList
-%type<ast> expr toplevelExpr
+%type<ast> expr appExpr atomExpr toplevelExpr
+%type<astlist> exprList
 %type<assign> binding
 %type<assignlist> bindingList
 %type<arglist> arglist
-
-%nonassoc IFPREC
+%type<typ> typeExpr typeApp typeAtom
+%type<types> typeArgs
+%type<conDecl> conDecl
+%type<conDecls> conDeclList
+%type<pat> pattern appPattern atomPattern atomPatternNoCon
+%type<pats> patternArgs patternList
+%type<alt> caseAlt
+%type<alts> caseAltList
+%type<syms> importList identList
+
+%nonassoc LAMBDAPREC
+%right tokOr
+%right tokAnd
+// Comparisons bind looser than arithmetic. They group to the right, as they
+// used to: "a < b < c" is "a < (b < c)".
+%right tokEQ tokNEQ tokGE tokLE '<' '>'
+%right ':' tokConcat
 %left '-' '+'
-%left '*' '/'
+%left '*' '/' '%'
+%left UMINUS
 
 %%
 
@@ -44,37 +69,121 @@ main: toplevelExprList { yylex.(*parser).result = $1 }
 toplevelExprList: toplevelExpr { $$ = []ASTNode{$1} }
   | toplevelExprList ';' toplevelExpr { $$ = append($1, $3)}
 
-toplevelExpr: tokIdent arglist '=' expr {
-    rhs := $4
-    if len($2) > 0 {
-      rhs = newLambda(lexpos(yylex), $2, $4)
-    }
-    $$ = &ASTAssign{pos: lexpos(yylex), Sym: InternSymbol($1), Expr: rhs}
-  }
+toplevelExpr: appExpr '=' expr { $$ = newAssign(yylex, $1, $3) }
   | expr { $$ = $1 }
+  | tokData tokConIdent arglist '=' conDeclList { $$ = newData($<pos>1, $2, $3, $5) }
+  | tokModule tokConIdent { $$ = &ASTModule{pos: $<pos>1, Name: $2} }
+  | tokImport tokConIdent importList { $$ = &ASTImport{pos: $<pos>1, Module: $2, Names: $3} }
+  | error { $$ = nil }
+
+importList: { $$ = nil }
+  | '(' ')' { $$ = []Symbol{} }
+  | '(' identList ')' { $$ = $2 }
+
+identList: tokIdent { $$ = []Symbol{InternSymbol($1)} }
+  | identList ',' tokIdent { $$ = append($1, InternSymbol($3)) }
+
+conDeclList: conDecl { $$ = []*ASTConDecl{$1} }
+  | conDeclList '|' conDecl { $$ = append($1, $3) }
+
+conDecl: tokConIdent typeArgs { $$ = &ASTConDecl{pos: $<pos>1, Sym: InternSymbol($1), Fields: $2} }
+
+typeExpr: typeApp
+  | typeApp tokArrow typeExpr { $$ = newFuncType($1, $3) }
+
+typeApp: tokConIdent typeArgs { $$ = &TypeCon{Name: $1, Args: $2} }
+  | tokIdent { $$ = &TypeVar{Name: $1} }
+  | '(' typeExpr ')' { $$ = $2 }
+  | '[' typeExpr ']' { $$ = newListType($2) }
+
+typeArgs: { $$ = nil }
+  | typeArgs typeAtom { $$ = append($1, $2) }
+
+typeAtom: tokConIdent { $$ = &TypeCon{Name: $1} }
+  | tokIdent { $$ = &TypeVar{Name: $1} }
+  | '(' typeExpr ')' { $$ = $2 }
+  | '[' typeExpr ']' { $$ = newListType($2) }
 
 arglist: { $$ = nil }
   | arglist tokIdent { $$ = append($1, $2) }
 
-expr: tokLiteral
-  | expr expr { $$ = &ASTApply{pos: lexpos(yylex), Head:$1, Tail:$2} }
-  | tokIf expr expr expr %prec IFPREC { $$ = &ASTIf{Cond: $2, Then: $3, Else: $4}}
-  | tokIdent { $$ = &ASTVar{pos: lexpos(yylex), Sym: InternSymbol($1)} }
-  | expr '+' expr { $$ = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:+"], Args: []ASTNode{$1, $3} } }
-  | expr '-' expr { $$ = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:-"], Args: []ASTNode{$1, $3} } }
-  | expr '*' expr { $$ = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:*"], Args: []ASTNode{$1, $3} } }
-  | expr tokEQ expr { $$ = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:=="], Args: []ASTNode{$1, $3} } }
-  | expr tokNEQ expr { $$ = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:!="], Args: []ASTNode{$1, $3} } }
-  | expr tokGE expr { $$ = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:>="], Args: []ASTNode{$1, $3} } }
-  | expr tokLE expr { $$ = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:<="], Args: []ASTNode{$1, $3} } }
-  | expr '<' expr { $$ = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:<"], Args: []ASTNode{$1, $3} } }
-  | expr '>' expr { $$ = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:>"], Args: []ASTNode{$1, $3} } }
+expr: appExpr
+  | expr '+' expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:+"], Args: []ASTNode{$1, $3} } }
+  | expr '-' expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:-"], Args: []ASTNode{$1, $3} } }
+  | expr '*' expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:*"], Args: []ASTNode{$1, $3} } }
+  | expr '/' expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:/"], Args: []ASTNode{$1, $3} } }
+  | expr '%' expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:%"], Args: []ASTNode{$1, $3} } }
+  | '-' expr %prec UMINUS { $$ = newNegate($<pos>1, $2) }
+  | expr tokEQ expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:=="], Args: []ASTNode{$1, $3} } }
+  | expr tokNEQ expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:!="], Args: []ASTNode{$1, $3} } }
+  | expr tokGE expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:>="], Args: []ASTNode{$1, $3} } }
+  | expr tokLE expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:<="], Args: []ASTNode{$1, $3} } }
+  | expr '<' expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:<"], Args: []ASTNode{$1, $3} } }
+  | expr '>' expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:>"], Args: []ASTNode{$1, $3} } }
+  | expr tokConcat expr { $$ = &ASTApplyLeafFunction{pos: $<pos>1, Op: funcs["builtin:++"], Args: []ASTNode{$1, $3} } }
+  | expr ':' expr { $$ = newCons($<pos>1, $<pos>2, $1, $3) }
+  | expr tokAnd expr { $$ = &ASTIf{pos: $<pos>1, Cond: $1, Then: $3, Else: newBool($<pos>2, false)} }
+  | expr tokOr expr { $$ = &ASTIf{pos: $<pos>1, Cond: $1, Then: newBool($<pos>2, true), Else: $3} }
+  | '\\' tokIdent arglist tokArrow expr %prec LAMBDAPREC { $$ = newLambda($<pos>1, append([]string{$2}, $3...), $5) }
+  | tokLetrec bindingList tokIn expr %prec LAMBDAPREC { $$ = &ASTLetrec{pos: $<pos>1, Bindings: $2, Body: $4} }
+  // The condition and the then branch are atoms. The else branch extends as far
+  // as possible: "if c x y + 1" is "if c x (y + 1)".
+  | tokIf atomExpr atomExpr expr %prec LAMBDAPREC { $$ = &ASTIf{pos: $<pos>1, Cond: $2, Then: $3, Else: $4}}
+
+// Function application binds tighter than any operator, and it is
+// left-associative: "f x y" is "(f x) y".
+appExpr: atomExpr
+  | appExpr atomExpr { $$ = &ASTApply{pos: $<pos>1, Head:$1, Tail:$2} }
+
+atomExpr: tokLiteral
+  | tokIdent { $$ = &ASTVar{pos: $<pos>1, Sym: InternSymbol($1)} }
+  | tokQualIdent { $$ = &ASTVar{pos: $<pos>1, Sym: InternSymbol($1)} }
+  | tokConIdent { $$ = &ASTCon{pos: $<pos>1, Sym: InternSymbol($1)} }
+  | '[' ']' { $$ = &ASTCon{pos: $<pos>1, Sym: nilSpec.sym} }
+  | '[' exprList ']' { $$ = newList($<pos>1, $2) }
+  | '(' ':' ')' { $$ = &ASTCon{pos: $<pos>1, Sym: consSpec.sym} }
+  | tokCase expr tokOf '{' caseAltList '}' { $$ = &ASTCase{pos: $<pos>1, Expr: $2, Alts: $5} }
   | '(' expr ')' { $$ = $2 }
-  | '\\' arglist tokArrow expr { $$ = newLambda(lexpos(yylex), $2, $4) }
-  | tokLetrec bindingList tokIn expr { $$ = &ASTLetrec{pos: lexpos(yylex), Bindings: $2, Body: $4} }
+  | '(' error ')' { $$ = nil }
 
 bindingList:
   binding { $$ = []*ASTAssign{$1} }
   | bindingList ';' binding { $$ = append($1, $3) }
 
-binding: tokIdent '=' expr {$$ = &ASTAssign{pos: lexpos(yylex), Sym: InternSymbol($1), Expr: $3}}
+binding: appExpr '=' expr { $$ = newAssign(yylex, $1, $3) }
+
+exprList: expr { $$ = []ASTNode{$1} }
+  | exprList ',' expr { $$ = append($1, $3) }
+
+caseAltList: caseAlt { $$ = []ASTCaseAlt{$1} }
+  | caseAltList ';' caseAlt { $$ = append($1, $3) }
+
+caseAlt: pattern tokArrow expr { $$ = ASTCaseAlt{Pat: $1, Body: $3} }
+
+pattern: appPattern
+  | appPattern ':' pattern { $$ = &ASTPatCon{pos: $<pos>1, Sym: consSpec.sym, Args: []ASTPattern{$1, $3}} }
+
+appPattern: tokConIdent patternArgs { $$ = &ASTPatCon{pos: $<pos>1, Sym: InternSymbol($1), Args: $2} }
+  | atomPatternNoCon
+
+patternArgs: { $$ = nil }
+  | patternArgs atomPattern { $$ = append($1, $2) }
+
+atomPattern: tokConIdent { $$ = &ASTPatCon{pos: $<pos>1, Sym: InternSymbol($1)} }
+  | atomPatternNoCon
+
+atomPatternNoCon: tokIdent {
+    if $1 == "_" {
+      $$ = &ASTPatWildcard{pos: $<pos>1}
+    } else {
+      $$ = &ASTPatVar{pos: $<pos>1, Sym: InternSymbol($1)}
+    }
+  }
+  | tokLiteral { $$ = &ASTPatLiteral{pos: $<pos>1, Val: $1.(*ASTConst).Val} }
+  | '-' tokLiteral { $$ = newNegativePattern(yylex, $<pos>1, $2.(*ASTConst).Val) }
+  | '(' pattern ')' { $$ = $2 }
+  | '[' ']' { $$ = &ASTPatCon{pos: $<pos>1, Sym: nilSpec.sym} }
+  | '[' patternList ']' { $$ = newListPattern($<pos>1, $2) }
+
+patternList: pattern { $$ = []ASTPattern{$1} }
+  | patternList ',' pattern { $$ = append($1, $3) }
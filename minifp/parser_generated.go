@@ -7,59 +7,98 @@ import (
 	"text/scanner"
 )
 
-func lexpos(yylex yyLexer) scanner.Position {
-	return yylex.(*parser).sc.Pos()
-}
-
 type yySymType struct {
-	yys        int
+	yys int
+	// pos is the start of the token or the nonterminal. Lex sets it for
+	// tokens; goyacc copies it from the leftmost symbol for nonterminals.
+	pos        scanner.Position
 	astlist    []ASTNode
 	ast        ASTNode
 	assign     *ASTAssign
 	assignlist []*ASTAssign
 	arglist    []string
 	ident      string
+	typ        Type
+	types      []Type
+	conDecl    *ASTConDecl
+	conDecls   []*ASTConDecl
+	pat        ASTPattern
+	pats       []ASTPattern
+	alt        ASTCaseAlt
+	alts       []ASTCaseAlt
+	syms       []Symbol
 }
 
 const tokIdent = 57346
-const tokLetrec = 57347
-const tokIn = 57348
-const tokIf = 57349
-const tokLiteral = 57350
-const tokArrow = 57351
-const tokEQ = 57352
-const tokNEQ = 57353
-const tokGE = 57354
-const tokLE = 57355
-const IFPREC = 57356
+const tokConIdent = 57347
+const tokQualIdent = 57348
+const tokLetrec = 57349
+const tokIn = 57350
+const tokIf = 57351
+const tokData = 57352
+const tokCase = 57353
+const tokOf = 57354
+const tokModule = 57355
+const tokImport = 57356
+const tokLiteral = 57357
+const tokArrow = 57358
+const tokEQ = 57359
+const tokNEQ = 57360
+const tokGE = 57361
+const tokLE = 57362
+const tokConcat = 57363
+const tokAnd = 57364
+const tokOr = 57365
+const LAMBDAPREC = 57366
+const UMINUS = 57367
 
 var yyToknames = [...]string{
 	"$end",
 	"error",
 	"$unk",
 	"tokIdent",
+	"tokConIdent",
+	"tokQualIdent",
 	"tokLetrec",
 	"tokIn",
 	"tokIf",
+	"tokData",
+	"tokCase",
+	"tokOf",
+	"tokModule",
+	"tokImport",
 	"tokLiteral",
 	"tokArrow",
 	"tokEQ",
 	"tokNEQ",
 	"tokGE",
 	"tokLE",
-	"IFPREC",
+	"tokConcat",
+	"tokAnd",
+	"tokOr",
+	"LAMBDAPREC",
+	"'<'",
+	"'>'",
+	"':'",
 	"'-'",
 	"'+'",
 	"'*'",
 	"'/'",
+	"'%'",
+	"UMINUS",
 	"';'",
 	"'='",
-	"'<'",
-	"'>'",
 	"'('",
 	"')'",
+	"','",
+	"'|'",
+	"'['",
+	"']'",
 	"'\\\\'",
+	"'{'",
+	"'}'",
 }
+
 var yyStatenames = [...]string{}
 
 const yyEofCode = 1
@@ -70,95 +109,164 @@ var yyExca = [...]int{
 	-1, 1,
 	1, -1,
 	-2, 0,
-	-1, 4,
-	4, 6,
-	20, 6,
-	-2, 11,
 }
 
 const yyPrivate = 57344
 
-const yyLast = 148
+const yyLast = 363
 
 var yyAct = [...]int{
-
-	13, 5, 28, 47, 11, 29, 27, 2, 24, 25,
-	23, 10, 5, 7, 6, 33, 34, 35, 36, 37,
-	38, 39, 40, 41, 32, 42, 3, 12, 45, 8,
-	32, 9, 48, 1, 0, 44, 0, 26, 30, 0,
-	31, 46, 0, 49, 0, 50, 51, 0, 53, 52,
-	23, 10, 0, 7, 6, 0, 17, 18, 19, 20,
-	0, 15, 14, 16, 0, 0, 0, 21, 22, 8,
-	43, 9, 23, 10, 0, 7, 6, 0, 17, 18,
-	19, 20, 0, 15, 14, 16, 0, 0, 0, 21,
-	22, 8, 0, 9, 23, 10, 0, 7, 6, 0,
-	17, 18, 19, 20, 0, 0, 0, 16, 0, 0,
-	0, 21, 22, 8, 0, 9, 23, 10, 0, 7,
-	6, 0, 17, 18, 19, 20, 4, 10, 0, 7,
-	6, 0, 0, 21, 22, 8, 0, 9, 0, 0,
-	0, 0, 0, 0, 0, 8, 0, 9,
+	145, 108, 111, 5, 118, 101, 107, 74, 47, 121,
+	99, 144, 161, 158, 143, 43, 117, 83, 92, 120,
+	82, 103, 104, 52, 54, 56, 160, 58, 153, 59,
+	60, 61, 62, 63, 64, 65, 66, 67, 68, 69,
+	70, 71, 72, 73, 44, 4, 142, 133, 132, 86,
+	84, 90, 9, 77, 16, 18, 17, 13, 48, 14,
+	6, 21, 76, 7, 8, 15, 22, 4, 55, 123,
+	16, 18, 17, 13, 89, 14, 3, 21, 11, 134,
+	154, 15, 94, 135, 96, 97, 20, 98, 95, 78,
+	19, 89, 12, 53, 11, 10, 122, 105, 125, 57,
+	24, 102, 20, 93, 42, 88, 19, 41, 12, 40,
+	49, 148, 147, 119, 45, 79, 91, 126, 129, 27,
+	28, 29, 75, 130, 48, 138, 137, 141, 136, 106,
+	16, 18, 17, 13, 128, 14, 151, 21, 124, 139,
+	24, 15, 109, 149, 24, 81, 152, 150, 100, 131,
+	156, 157, 155, 146, 11, 159, 46, 51, 16, 18,
+	17, 13, 20, 14, 36, 21, 19, 50, 12, 15,
+	37, 26, 25, 27, 28, 29, 2, 1, 0, 0,
+	0, 0, 11, 0, 0, 0, 0, 0, 0, 0,
+	20, 0, 0, 0, 19, 0, 12, 30, 31, 32,
+	33, 36, 38, 39, 0, 34, 35, 37, 26, 25,
+	27, 28, 29, 112, 110, 0, 0, 85, 30, 31,
+	32, 33, 36, 38, 113, 0, 34, 35, 37, 26,
+	25, 27, 28, 29, 0, 0, 0, 114, 0, 0,
+	0, 0, 0, 0, 0, 115, 87, 0, 0, 116,
+	127, 30, 31, 32, 33, 36, 38, 39, 0, 34,
+	35, 37, 26, 25, 27, 28, 29, 16, 18, 17,
+	16, 18, 17, 0, 21, 0, 0, 21, 15, 0,
+	0, 15, 30, 31, 32, 33, 36, 38, 39, 0,
+	34, 35, 37, 26, 25, 27, 28, 29, 80, 20,
+	0, 23, 20, 19, 112, 110, 19, 16, 18, 17,
+	112, 140, 0, 0, 21, 113, 0, 0, 15, 0,
+	0, 113, 0, 0, 0, 0, 0, 0, 114, 0,
+	0, 0, 0, 0, 114, 0, 115, 0, 0, 20,
+	116, 0, 115, 19, 0, 0, 116, 30, 31, 32,
+	33, 36, 0, 0, 0, 34, 35, 37, 26, 25,
+	27, 28, 29,
 }
-var yyPact = [...]int{
 
-	122, -1000, -15, -1000, -1000, 68, -1000, 6, 6, -1000,
-	1, 122, 20, 68, 6, 6, 6, 6, 6, 6,
-	6, 6, 6, -1000, 68, 46, 26, 22, -1000, -17,
-	-1000, 6, -1000, 90, 90, 112, 68, 68, 68, 68,
-	68, 68, 68, -1000, 6, 6, 1, 6, 68, 68,
-	68, 68, -1000, 68,
+var yyPact = [...]int{
+	50, -1000, 32, -1000, 266, 265, 104, 102, 99, -1000,
+	-1000, 154, 110, 303, 303, -1000, -1000, -1000, -1000, 126,
+	66, 154, 50, 154, -1000, 154, 154, 154, 154, 154,
+	154, 154, 154, 154, 154, 154, 154, 154, 154, 154,
+	-1000, -1000, 26, -1000, 303, -1000, 81, -1000, 263, 303,
+	-1000, -21, 265, 13, 180, 12, 234, -1000, 265, 89,
+	89, -1000, -1000, -1000, 330, 330, 330, 330, 330, 330,
+	143, 143, 201, 265, 70, -1000, 14, 87, 154, 303,
+	154, 154, -1000, 154, -1000, -1000, -1000, -33, 96, -1000,
+	-1000, -16, -1000, 154, 265, -1000, 265, 265, 265, 300,
+	-23, -1000, -1000, -1000, 109, 265, -25, -1000, 80, 42,
+	-1000, -1000, -1000, -1000, 83, 300, 209, 96, 43, -1000,
+	-1000, 300, 154, 300, 306, -1000, 9, -1000, -27, -1000,
+	-1000, -1000, -1000, -1000, 107, 107, -1000, 265, -1000, -1000,
+	-1000, -1000, -1000, -1000, 300, -9, 64, -1000, -1000, 107,
+	107, -28, -1000, -1000, 107, 43, -11, -29, -1000, -1000,
+	-1000, -1000,
 }
-var yyPgo = [...]int{
 
-	0, 33, 7, 0, 26, 2, 6, 27,
+var yyPgo = [...]int{
+	0, 177, 176, 3, 44, 95, 76, 157, 8, 156,
+	7, 0, 153, 149, 4, 5, 148, 1, 142, 139,
+	2, 138, 134, 6, 129, 122, 116,
 }
-var yyR1 = [...]int{
 
-	0, 1, 2, 2, 4, 4, 7, 7, 3, 3,
+var yyR1 = [...]int{
+	0, 1, 2, 2, 6, 6, 6, 6, 6, 6,
+	25, 25, 25, 26, 26, 16, 16, 15, 11, 11,
+	12, 12, 12, 12, 14, 14, 13, 13, 13, 13,
+	10, 10, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 6, 6, 5,
+	3, 3, 4, 4, 5, 5, 5, 5, 5, 5,
+	5, 5, 5, 5, 9, 9, 8, 7, 7, 24,
+	24, 23, 17, 17, 18, 18, 21, 21, 19, 19,
+	20, 20, 20, 20, 20, 20, 22, 22,
 }
-var yyR2 = [...]int{
 
-	0, 1, 1, 3, 4, 1, 0, 2, 1, 2,
-	4, 1, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 4, 4, 1, 3, 3,
+var yyR2 = [...]int{
+	0, 1, 1, 3, 3, 1, 5, 2, 3, 1,
+	0, 2, 3, 1, 3, 1, 3, 2, 1, 3,
+	2, 1, 3, 3, 0, 2, 1, 1, 3, 3,
+	0, 2, 1, 3, 3, 3, 3, 3, 2, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 5,
+	4, 4, 1, 2, 1, 1, 1, 1, 2, 3,
+	3, 6, 3, 3, 1, 3, 3, 1, 3, 1,
+	3, 3, 1, 3, 2, 1, 0, 2, 1, 1,
+	1, 1, 2, 3, 2, 3, 1, 3,
 }
-var yyChk = [...]int{
 
-	-1000, -1, -2, -4, 4, -3, 8, 7, 23, 25,
-	5, 19, -7, -3, 16, 15, 17, 10, 11, 12,
-	13, 21, 22, 4, -3, -3, -7, -6, -5, 4,
-	-4, 20, 4, -3, -3, -3, -3, -3, -3, -3,
-	-3, -3, -3, 24, 9, 6, 19, 20, -3, -3,
-	-3, -3, -5, -3,
+var yyChk = [...]int{
+	-1000, -1, -2, -6, -4, -3, 10, 13, 14, 2,
+	-5, 28, 42, 7, 9, 15, 4, 6, 5, 40,
+	36, 11, 34, 35, -5, 29, 28, 30, 31, 32,
+	17, 18, 19, 20, 25, 26, 21, 27, 22, 23,
+	5, 5, 5, -3, -4, 4, -9, -8, -4, -5,
+	41, -7, -3, 27, -3, 2, -3, -6, -3, -3,
+	-3, -3, -3, -3, -3, -3, -3, -3, -3, -3,
+	-3, -3, -3, -3, -10, -25, 36, -10, 8, 34,
+	35, -5, 41, 38, 37, 37, 37, 12, 35, 4,
+	37, -26, 4, 16, -3, -8, -3, -3, -3, 43,
+	-16, -15, 5, 37, 38, -3, -24, -23, -17, -18,
+	5, -20, 4, 15, 28, 36, 40, 39, -14, 4,
+	44, 34, 16, 27, -21, 15, -17, 41, -22, -17,
+	-15, -13, 5, 4, 36, 40, -23, -3, -17, -19,
+	5, -20, 37, 41, 38, -11, -12, 5, 4, 36,
+	40, -11, -17, 37, 16, -14, -11, -11, 41, -11,
+	37, 41,
 }
-var yyDef = [...]int{
 
-	0, -2, 1, 2, -2, 5, 8, 0, 0, 6,
-	0, 0, 0, 9, 0, 0, 0, 0, 0, 0,
-	0, 0, 0, 11, 0, 0, 0, 0, 24, 0,
-	3, 0, 7, 12, 13, 14, 15, 16, 17, 18,
-	19, 20, 0, 21, 0, 0, 0, 0, 4, 10,
-	22, 23, 25, 26,
+var yyDef = [...]int{
+	0, -2, 1, 2, 32, 5, 0, 0, 0, 9,
+	52, 0, 0, 0, 0, 54, 55, 56, 57, 0,
+	0, 0, 0, 0, 53, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	30, 7, 10, 38, 32, 30, 0, 64, 0, 0,
+	58, 0, 67, 0, 0, 0, 0, 3, 4, 33,
+	34, 35, 36, 37, 39, 40, 41, 42, 43, 44,
+	45, 46, 47, 48, 0, 8, 0, 0, 0, 0,
+	0, 0, 59, 0, 60, 62, 63, 0, 0, 31,
+	11, 0, 13, 0, 50, 65, 66, 51, 68, 0,
+	6, 15, 24, 12, 0, 49, 0, 69, 0, 72,
+	76, 75, 80, 81, 0, 0, 0, 0, 17, 14,
+	61, 0, 0, 0, 74, 82, 0, 84, 0, 86,
+	16, 25, 26, 27, 0, 0, 70, 71, 73, 77,
+	78, 79, 83, 85, 0, 0, 18, 24, 21, 0,
+	0, 0, 87, 28, 0, 20, 0, 0, 29, 19,
+	22, 23,
 }
-var yyTok1 = [...]int{
 
+var yyTok1 = [...]int{
 	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 32, 3, 3,
+	36, 37, 30, 29, 38, 28, 3, 31, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 27, 34,
+	25, 35, 26, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	23, 24, 17, 16, 3, 15, 3, 18, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 3, 19,
-	21, 20, 22, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 40, 42, 41, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 25,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 43, 39, 44,
 }
-var yyTok2 = [...]int{
 
+var yyTok2 = [...]int{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
-	12, 13, 14,
+	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
+	22, 23, 24, 33,
 }
+
 var yyTok3 = [...]int{
 	0,
 }
@@ -514,13 +622,9 @@ yydefault:
 			yyVAL.astlist = append(yyDollar[1].astlist, yyDollar[3].ast)
 		}
 	case 4:
-		yyDollar = yyS[yypt-4 : yypt+1]
+		yyDollar = yyS[yypt-3 : yypt+1]
 		{
-			rhs := yyDollar[4].ast
-			if len(yyDollar[2].arglist) > 0 {
-				rhs = newLambda(lexpos(yylex), yyDollar[2].arglist, yyDollar[4].ast)
-			}
-			yyVAL.ast = &ASTAssign{pos: lexpos(yylex), Sym: InternSymbol(yyDollar[1].ident), Expr: rhs}
+			yyVAL.ast = newAssign(yylex, yyDollar[1].ast, yyDollar[3].ast)
 		}
 	case 5:
 		yyDollar = yyS[yypt-1 : yypt+1]
@@ -528,104 +632,383 @@ yydefault:
 			yyVAL.ast = yyDollar[1].ast
 		}
 	case 6:
-		yyDollar = yyS[yypt-0 : yypt+1]
+		yyDollar = yyS[yypt-5 : yypt+1]
 		{
-			yyVAL.arglist = nil
+			yyVAL.ast = newData(yyDollar[1].pos, yyDollar[2].ident, yyDollar[3].arglist, yyDollar[5].conDecls)
 		}
 	case 7:
 		yyDollar = yyS[yypt-2 : yypt+1]
 		{
-			yyVAL.arglist = append(yyDollar[1].arglist, yyDollar[2].ident)
+			yyVAL.ast = &ASTModule{pos: yyDollar[1].pos, Name: yyDollar[2].ident}
+		}
+	case 8:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTImport{pos: yyDollar[1].pos, Module: yyDollar[2].ident, Names: yyDollar[3].syms}
 		}
 	case 9:
-		yyDollar = yyS[yypt-2 : yypt+1]
+		yyDollar = yyS[yypt-1 : yypt+1]
 		{
-			yyVAL.ast = &ASTApply{pos: lexpos(yylex), Head: yyDollar[1].ast, Tail: yyDollar[2].ast}
+			yyVAL.ast = nil
 		}
 	case 10:
-		yyDollar = yyS[yypt-4 : yypt+1]
+		yyDollar = yyS[yypt-0 : yypt+1]
 		{
-			yyVAL.ast = &ASTIf{Cond: yyDollar[2].ast, Then: yyDollar[3].ast, Else: yyDollar[4].ast}
+			yyVAL.syms = nil
 		}
 	case 11:
-		yyDollar = yyS[yypt-1 : yypt+1]
+		yyDollar = yyS[yypt-2 : yypt+1]
 		{
-			yyVAL.ast = &ASTVar{pos: lexpos(yylex), Sym: InternSymbol(yyDollar[1].ident)}
+			yyVAL.syms = []Symbol{}
 		}
 	case 12:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
-			yyVAL.ast = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:+"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+			yyVAL.syms = yyDollar[2].syms
 		}
 	case 13:
-		yyDollar = yyS[yypt-3 : yypt+1]
+		yyDollar = yyS[yypt-1 : yypt+1]
 		{
-			yyVAL.ast = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:-"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+			yyVAL.syms = []Symbol{InternSymbol(yyDollar[1].ident)}
 		}
 	case 14:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
-			yyVAL.ast = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:*"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+			yyVAL.syms = append(yyDollar[1].syms, InternSymbol(yyDollar[3].ident))
 		}
 	case 15:
-		yyDollar = yyS[yypt-3 : yypt+1]
+		yyDollar = yyS[yypt-1 : yypt+1]
 		{
-			yyVAL.ast = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:=="], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+			yyVAL.conDecls = []*ASTConDecl{yyDollar[1].conDecl}
 		}
 	case 16:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
-			yyVAL.ast = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:!="], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+			yyVAL.conDecls = append(yyDollar[1].conDecls, yyDollar[3].conDecl)
 		}
 	case 17:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.conDecl = &ASTConDecl{pos: yyDollar[1].pos, Sym: InternSymbol(yyDollar[1].ident), Fields: yyDollar[2].types}
+		}
+	case 19:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.typ = newFuncType(yyDollar[1].typ, yyDollar[3].typ)
+		}
+	case 20:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.typ = &TypeCon{Name: yyDollar[1].ident, Args: yyDollar[2].types}
+		}
+	case 21:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.typ = &TypeVar{Name: yyDollar[1].ident}
+		}
+	case 22:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
-			yyVAL.ast = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:>="], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+			yyVAL.typ = yyDollar[2].typ
 		}
-	case 18:
+	case 23:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
-			yyVAL.ast = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:<="], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+			yyVAL.typ = newListType(yyDollar[2].typ)
 		}
-	case 19:
+	case 24:
+		yyDollar = yyS[yypt-0 : yypt+1]
+		{
+			yyVAL.types = nil
+		}
+	case 25:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.types = append(yyDollar[1].types, yyDollar[2].typ)
+		}
+	case 26:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.typ = &TypeCon{Name: yyDollar[1].ident}
+		}
+	case 27:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.typ = &TypeVar{Name: yyDollar[1].ident}
+		}
+	case 28:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
-			yyVAL.ast = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:<"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+			yyVAL.typ = yyDollar[2].typ
 		}
-	case 20:
+	case 29:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
-			yyVAL.ast = &ASTApplyLeafFunction{pos: lexpos(yylex), Op: funcs["builtin:>"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+			yyVAL.typ = newListType(yyDollar[2].typ)
 		}
-	case 21:
+	case 30:
+		yyDollar = yyS[yypt-0 : yypt+1]
+		{
+			yyVAL.arglist = nil
+		}
+	case 31:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.arglist = append(yyDollar[1].arglist, yyDollar[2].ident)
+		}
+	case 33:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
-			yyVAL.ast = yyDollar[2].ast
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:+"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
 		}
-	case 22:
+	case 34:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:-"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 35:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:*"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 36:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:/"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 37:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:%"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 38:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.ast = newNegate(yyDollar[1].pos, yyDollar[2].ast)
+		}
+	case 39:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:=="], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 40:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:!="], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 41:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:>="], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 42:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:<="], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 43:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:<"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 44:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:>"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 45:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTApplyLeafFunction{pos: yyDollar[1].pos, Op: funcs["builtin:++"], Args: []ASTNode{yyDollar[1].ast, yyDollar[3].ast}}
+		}
+	case 46:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = newCons(yyDollar[1].pos, yyDollar[2].pos, yyDollar[1].ast, yyDollar[3].ast)
+		}
+	case 47:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTIf{pos: yyDollar[1].pos, Cond: yyDollar[1].ast, Then: yyDollar[3].ast, Else: newBool(yyDollar[2].pos, false)}
+		}
+	case 48:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTIf{pos: yyDollar[1].pos, Cond: yyDollar[1].ast, Then: newBool(yyDollar[2].pos, true), Else: yyDollar[3].ast}
+		}
+	case 49:
+		yyDollar = yyS[yypt-5 : yypt+1]
+		{
+			yyVAL.ast = newLambda(yyDollar[1].pos, append([]string{yyDollar[2].ident}, yyDollar[3].arglist...), yyDollar[5].ast)
+		}
+	case 50:
 		yyDollar = yyS[yypt-4 : yypt+1]
 		{
-			yyVAL.ast = newLambda(lexpos(yylex), yyDollar[2].arglist, yyDollar[4].ast)
+			yyVAL.ast = &ASTLetrec{pos: yyDollar[1].pos, Bindings: yyDollar[2].assignlist, Body: yyDollar[4].ast}
 		}
-	case 23:
+	case 51:
 		yyDollar = yyS[yypt-4 : yypt+1]
 		{
-			yyVAL.ast = &ASTLetrec{pos: lexpos(yylex), Bindings: yyDollar[2].assignlist, Body: yyDollar[4].ast}
+			yyVAL.ast = &ASTIf{pos: yyDollar[1].pos, Cond: yyDollar[2].ast, Then: yyDollar[3].ast, Else: yyDollar[4].ast}
 		}
-	case 24:
+	case 53:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.ast = &ASTApply{pos: yyDollar[1].pos, Head: yyDollar[1].ast, Tail: yyDollar[2].ast}
+		}
+	case 55:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.ast = &ASTVar{pos: yyDollar[1].pos, Sym: InternSymbol(yyDollar[1].ident)}
+		}
+	case 56:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.ast = &ASTVar{pos: yyDollar[1].pos, Sym: InternSymbol(yyDollar[1].ident)}
+		}
+	case 57:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.ast = &ASTCon{pos: yyDollar[1].pos, Sym: InternSymbol(yyDollar[1].ident)}
+		}
+	case 58:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.ast = &ASTCon{pos: yyDollar[1].pos, Sym: nilSpec.sym}
+		}
+	case 59:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = newList(yyDollar[1].pos, yyDollar[2].astlist)
+		}
+	case 60:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = &ASTCon{pos: yyDollar[1].pos, Sym: consSpec.sym}
+		}
+	case 61:
+		yyDollar = yyS[yypt-6 : yypt+1]
+		{
+			yyVAL.ast = &ASTCase{pos: yyDollar[1].pos, Expr: yyDollar[2].ast, Alts: yyDollar[5].alts}
+		}
+	case 62:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = yyDollar[2].ast
+		}
+	case 63:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.ast = nil
+		}
+	case 64:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
 			yyVAL.assignlist = []*ASTAssign{yyDollar[1].assign}
 		}
-	case 25:
+	case 65:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.assignlist = append(yyDollar[1].assignlist, yyDollar[3].assign)
 		}
-	case 26:
+	case 66:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.assign = newAssign(yylex, yyDollar[1].ast, yyDollar[3].ast)
+		}
+	case 67:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.astlist = []ASTNode{yyDollar[1].ast}
+		}
+	case 68:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.astlist = append(yyDollar[1].astlist, yyDollar[3].ast)
+		}
+	case 69:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.alts = []ASTCaseAlt{yyDollar[1].alt}
+		}
+	case 70:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.alts = append(yyDollar[1].alts, yyDollar[3].alt)
+		}
+	case 71:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.alt = ASTCaseAlt{Pat: yyDollar[1].pat, Body: yyDollar[3].ast}
+		}
+	case 73:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.pat = &ASTPatCon{pos: yyDollar[1].pos, Sym: consSpec.sym, Args: []ASTPattern{yyDollar[1].pat, yyDollar[3].pat}}
+		}
+	case 74:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.pat = &ASTPatCon{pos: yyDollar[1].pos, Sym: InternSymbol(yyDollar[1].ident), Args: yyDollar[2].pats}
+		}
+	case 76:
+		yyDollar = yyS[yypt-0 : yypt+1]
+		{
+			yyVAL.pats = nil
+		}
+	case 77:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.pats = append(yyDollar[1].pats, yyDollar[2].pat)
+		}
+	case 78:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.pat = &ASTPatCon{pos: yyDollar[1].pos, Sym: InternSymbol(yyDollar[1].ident)}
+		}
+	case 80:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			if yyDollar[1].ident == "_" {
+				yyVAL.pat = &ASTPatWildcard{pos: yyDollar[1].pos}
+			} else {
+				yyVAL.pat = &ASTPatVar{pos: yyDollar[1].pos, Sym: InternSymbol(yyDollar[1].ident)}
+			}
+		}
+	case 81:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.pat = &ASTPatLiteral{pos: yyDollar[1].pos, Val: yyDollar[1].ast.(*ASTConst).Val}
+		}
+	case 82:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.pat = newNegativePattern(yylex, yyDollar[1].pos, yyDollar[2].ast.(*ASTConst).Val)
+		}
+	case 83:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.pat = yyDollar[2].pat
+		}
+	case 84:
+		yyDollar = yyS[yypt-2 : yypt+1]
+		{
+			yyVAL.pat = &ASTPatCon{pos: yyDollar[1].pos, Sym: nilSpec.sym}
+		}
+	case 85:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.pat = newListPattern(yyDollar[1].pos, yyDollar[2].pats)
+		}
+	case 86:
+		yyDollar = yyS[yypt-1 : yypt+1]
+		{
+			yyVAL.pats = []ASTPattern{yyDollar[1].pat}
+		}
+	case 87:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
-			yyVAL.assign = &ASTAssign{pos: lexpos(yylex), Sym: InternSymbol(yyDollar[1].ident), Expr: yyDollar[3].ast}
+			yyVAL.pats = append(yyDollar[1].pats, yyDollar[3].pat)
 		}
 	}
 	goto yystack /* stack new state and value */
@@ -0,0 +1,78 @@
+package minifp
+
+import (
+	_ "embed"
+	"strings"
+	"sync"
+)
+
+// preludeSrc defines the functions available to every program, unless the
+// machine is created with NoPrelude.
+//
+//go:embed prelude.mfp
+var preludeSrc string
+
+// NoPrelude creates a machine without the prelude. Only the builtins are
+// available.
+func NoPrelude() Option { return func(k *KMachine) { k.noPrelude = true } }
+
+// NoPreludeTypes creates a type checker that doesn't know the functions of the
+// prelude, to match a machine created with NoPrelude.
+func NoPreludeTypes() CheckerOption { return func(c *TypeChecker) { c.noPrelude = true } }
+
+var (
+	preludeOnce  sync.Once
+	preludeNodes []ASTNode
+)
+
+// prelude returns the parsed preludeSrc.
+func prelude() []ASTNode {
+	preludeOnce.Do(func() {
+		nodes, err := ParseFile("<prelude>", strings.NewReader(preludeSrc))
+		if err != nil {
+			panic(err)
+		}
+		preludeNodes = nodes
+	})
+	return preludeNodes
+}
+
+var (
+	preludeCheckerOnce sync.Once
+	preludeChecker     *TypeChecker
+)
+
+// checkedPrelude returns a type checker that has checked the prelude. It must
+// not be modified; NewTypeChecker copies it.
+func checkedPrelude() *TypeChecker {
+	preludeCheckerOnce.Do(func() {
+		c := newTypeChecker()
+		for _, node := range prelude() {
+			if _, err := c.Check(node); err != nil {
+				panic(err)
+			}
+		}
+		preludeChecker = c
+	})
+	return preludeChecker
+}
+
+var (
+	preludeMachineOnce sync.Once
+	preludeMachine     *KMachine
+)
+
+// compiledPrelude returns a machine that has compiled the prelude, but hasn't
+// run it. It must not be modified; NewMachine copies its globals and
+// constructors. The compiled code doesn't depend on the machine, so it is
+// shared.
+func compiledPrelude() *KMachine {
+	preludeMachineOnce.Do(func() {
+		k := newMachine()
+		for _, node := range prelude() {
+			k.Compile(node)
+		}
+		preludeMachine = k
+	})
+	return preludeMachine
+}
@@ -0,0 +1,100 @@
+package minifp_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+func TestPrelude(t *testing.T) {
+	km := minifp.NewMachine()
+	for _, test := range []struct{ src, want string }{
+		{`id 3`, "3"},
+		{`const 1 "x"`, "1"},
+		{`compose (\x -> x + 1) (\x -> x * 2) 5`, "11"},
+		{`flip (\x y -> x - y) 1 10`, "9"},
+		{`fix (\f n -> if (n == 0) 1 (n * f (n - 1))) 5`, "120"},
+		{`take 3 (fix (\xs -> 1 : xs))`, "[1, 1, 1]"},
+		{`[true, false]`, "[true, false]"},
+		{`and true false`, "false"},
+		{`or false true`, "true"},
+		// The second argument is not evaluated.
+		{`and false (fix id)`, "false"},
+		{`or true (fix id)`, "true"},
+		{`max 3 7`, "7"},
+		{`min 3 7`, "3"},
+		{`max "b" "a"`, `"b"`},
+		{`abs (0 - 4)`, "4"},
+		{`abs (0.0 - 1.5)`, "1.5"},
+		{`sum (range 1 11)`, "55"},
+		{`product [1, 2, 3, 4]`, "24"},
+		{`sum []`, "0"},
+		{`fst (Pair 1 "a")`, "1"},
+		{`snd (Pair 1 "a")`, `"a"`},
+		{`all (\x -> x > 0) [1, 2]`, "true"},
+		{`all (\x -> x > 1) [1, 2]`, "false"},
+		{`any (\x -> x > 1) [1, 2]`, "true"},
+		{`any (\x -> x > 1) []`, "false"},
+		{`any (\x -> x > 1) (iterate (\x -> x + 1) 0)`, "true"},
+	} {
+		expect.EQ(t, run(t, km, test.src).String(), test.want, test.src)
+	}
+}
+
+func TestPreludeTypes(t *testing.T) {
+	for _, test := range []struct{ src, want string }{
+		{`id`, "a -> a"},
+		{`const`, "a -> b -> a"},
+		{`compose`, "(a -> b) -> (c -> a) -> c -> b"},
+		{`flip`, "(a -> b -> c) -> b -> a -> c"},
+		{`fix`, "(a -> a) -> a"},
+		{`and`, "Bool -> Bool -> Bool"},
+		{`max`, "a -> a -> a"},
+		{`abs`, "Int -> Int"},
+		{`sum`, "[Int] -> Int"},
+		{`fst`, "Pair a b -> a"},
+		{`all`, "(a -> Bool) -> [a] -> Bool"},
+	} {
+		got, err := typeOf(t, test.src)
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, got, test.want, test.src)
+	}
+}
+
+func TestNoPrelude(t *testing.T) {
+	km := minifp.NewMachine(minifp.NoPrelude())
+	expect.EQ(t, run(t, km, `not (strlen "ab" == 2)`).String(), "false")
+	x := minifp.Parse(strings.NewReader(`map`))
+	_, err := km.CompileErr(x[0])
+	expect.HasSubstr(t, err.Error(), "variable map not found")
+
+	tc := minifp.NewTypeChecker(minifp.NoPreludeTypes())
+	_, err = tc.Check(x[0])
+	expect.HasSubstr(t, err.Error(), "variable map not found")
+
+	// Pair is available without the prelude, so a map can be passed to Call.
+	typ, err := tc.Check(minifp.Parse(strings.NewReader(`Pair 1 "a"`))[0])
+	expect.NoError(t, err)
+	expect.EQ(t, typ.String(), "Pair Int String")
+	first := minifp.Parse(strings.NewReader(`first ps = case ps of {Pair k _ : _ -> k}`))[0]
+	_, err = tc.Check(first)
+	expect.NoError(t, err)
+	km.Compile(first)
+	got, err := km.Call("first", map[string]int{"b": 1, "a": 2})
+	expect.NoError(t, err)
+	expect.EQ(t, got, "a")
+
+	// A redefined Pair doesn't affect the conversion of a map.
+	km = minifp.NewMachine()
+	expect.EQ(t, run(t, km, `data Pair = Pair Int Int Int; first ps = case ps of {[] -> 0; _ : _ -> 1}; 1`).String(), "1")
+	got, err = km.Call("first", map[string]int{"a": 1})
+	expect.NoError(t, err)
+	expect.EQ(t, got, int64(1))
+
+	// The prelude can be redefined.
+	km = minifp.NewMachine()
+	expect.EQ(t, run(t, km, `max x y = 42; max 1 2`).String(), "42")
+	expect.EQ(t, run(t, km, `min 1 2`).String(), "1")
+}
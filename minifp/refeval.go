@@ -0,0 +1,329 @@
+package minifp
+
+import (
+	"errors"
+	"fmt"
+	"text/scanner"
+)
+
+// RefEvaluator evaluates expressions directly on the AST. It is a reference
+// implementation of the language for testing KMachine: it is slow, but simple
+// enough to be obviously correct. It is lazy, like the machine: an argument or
+// a letrec binding is a thunk, which is evaluated once when its value is
+// needed. Variables are looked up by name in a list of bindings.
+//
+// The prelude is available, but functions registered with a KMachine are not.
+type RefEvaluator struct {
+	// MaxSteps limits the number of evaluation steps that a single Eval call may
+	// take. Zero means no limit, in which case a non-terminating program may run
+	// out of Go stack.
+	MaxSteps int
+
+	globals map[Symbol]*refThunk
+	cons    map[Symbol]*conSpec
+	steps   int
+}
+
+// refValue is a value in weak head normal form. Exactly one of fn and con is
+// set, unless the value is a primitive, lit.
+type refValue struct {
+	lit Literal
+	fn  func(arg *refThunk) refValue
+	con *refCon
+}
+
+// refCon is a constructor applied to its arguments.
+type refCon struct {
+	spec   *conSpec
+	fields []*refThunk
+}
+
+// refThunk is an unevaluated expression, or its value once evaluated.
+type refThunk struct {
+	expr ASTNode
+	env  *refEnv
+	val  *refValue
+	// forcing is true while the thunk is being evaluated.
+	forcing bool
+}
+
+// refEnv binds a variable to its value. Next points to the enclosing
+// bindings.
+type refEnv struct {
+	sym  Symbol
+	val  *refThunk
+	next *refEnv
+}
+
+// refFailure aborts evaluation. It is recovered by Eval.
+type refFailure struct {
+	pos scanner.Position
+	err error
+}
+
+// NewRefEvaluator creates an evaluator with the prelude loaded.
+func NewRefEvaluator() *RefEvaluator {
+	e := &RefEvaluator{
+		globals: map[Symbol]*refThunk{},
+		cons:    map[Symbol]*conSpec{nilSpec.sym: nilSpec, consSpec.sym: consSpec, pairSpec.sym: pairSpec},
+	}
+	for _, node := range prelude() {
+		e.define(node)
+	}
+	return e
+}
+
+// define adds the global or the constructors defined by node, if any.
+func (e *RefEvaluator) define(node ASTNode) {
+	switch v := node.(type) {
+	case *ASTAssign:
+		e.globals[v.Sym] = &refThunk{expr: v.Expr}
+	case *ASTData:
+		for i, decl := range v.Cons {
+			e.cons[decl.Sym] = &conSpec{sym: decl.Sym, tag: i, arity: len(decl.Fields)}
+		}
+	}
+}
+
+// Eval evaluates a toplevel expression, like KMachine.RunErr after
+// KMachine.Compile. If node is an ASTAssign or ASTData, the global or the
+// constructors are defined first. The result is fully evaluated; a function
+// is returned as a LiteralFunc.
+func (e *RefEvaluator) Eval(node ASTNode) (val Literal, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(*refFailure)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%v: %w", f.pos, f.err)
+		}
+	}()
+	e.steps = 0
+	e.define(node)
+	switch v := node.(type) {
+	case *ASTData:
+		return kNil, nil
+	case *ASTAssign:
+		return e.literal(e.force(e.globals[v.Sym]), map[*refCon]*conValue{}), nil
+	}
+	return e.literal(e.eval(node, nil), map[*refCon]*conValue{}), nil
+}
+
+func (e *RefEvaluator) failf(pos scanner.Position, format string, args ...interface{}) {
+	panic(&refFailure{pos: pos, err: fmt.Errorf(format, args...)})
+}
+
+// eval evaluates node in env to weak head normal form.
+func (e *RefEvaluator) eval(node ASTNode, env *refEnv) refValue {
+	if e.steps++; e.MaxSteps > 0 && e.steps > e.MaxSteps {
+		panic(&refFailure{pos: node.Pos(), err: ErrStepLimit})
+	}
+	switch v := node.(type) {
+	case *ASTConst:
+		return refValue{lit: v.Val}
+	case *ASTVar:
+		return e.lookup(v, env)
+	case *ASTLambda:
+		return refValue{fn: func(arg *refThunk) refValue {
+			return e.eval(v.Body, &refEnv{sym: v.Arg, val: arg, next: env})
+		}}
+	case *ASTApply:
+		f := e.eval(v.Head, env)
+		if f.fn == nil {
+			e.failf(v.pos, "%v is not a function", v.Head)
+		}
+		return f.fn(&refThunk{expr: v.Tail, env: env})
+	case *ASTApplyLeafFunction:
+		args := make([]*refThunk, len(v.Args))
+		for i, arg := range v.Args {
+			args[i] = &refThunk{expr: arg, env: env}
+		}
+		return e.callBuiltin(v.pos, v.Op, args)
+	case *ASTLetrec:
+		thunks := make([]*refThunk, len(v.Bindings))
+		for i, b := range v.Bindings {
+			thunks[i] = &refThunk{expr: b.Expr}
+			env = &refEnv{sym: b.Sym, val: thunks[i], next: env}
+		}
+		// The bindings can refer to each other.
+		for _, t := range thunks {
+			t.env = env
+		}
+		return e.eval(v.Body, env)
+	case *ASTIf:
+		cond := e.eval(v.Cond, env)
+		if cond.fn != nil || cond.con != nil || cond.lit.typ != LiteralBool {
+			e.failf(v.pos, "if: expect a bool condition")
+		}
+		if cond.lit.Bool() {
+			return e.eval(v.Then, env)
+		}
+		return e.eval(v.Else, env)
+	case *ASTCon:
+		spec, ok := e.cons[v.Sym]
+		if !ok {
+			e.failf(v.pos, "constructor %v not found", v.Sym)
+		}
+		return e.construct(spec, nil)
+	case *ASTCase:
+		val := &refThunk{expr: v.Expr, env: env}
+		for _, alt := range v.Alts {
+			if altEnv, ok := e.match(alt.Pat, val, env); ok {
+				return e.eval(alt.Body, altEnv)
+			}
+		}
+		e.failf(v.pos, "non-exhaustive patterns in case")
+	}
+	e.failf(node.Pos(), "cannot evaluate %v", node)
+	panic("not reached")
+}
+
+// lookup finds the value of a variable: a local, a global, or a builtin.
+func (e *RefEvaluator) lookup(v *ASTVar, env *refEnv) refValue {
+	for b := env; b != nil; b = b.next {
+		if b.sym == v.Sym {
+			return e.force(b.val)
+		}
+	}
+	if t, ok := e.globals[v.Sym]; ok {
+		return e.force(t)
+	}
+	if op, ok := funcs[v.Sym.String()]; ok {
+		return e.builtin(v.pos, op, nil)
+	}
+	e.failf(v.pos, "variable %v not found", v.Sym)
+	panic("not reached")
+}
+
+// force evaluates the thunk unless it has been evaluated already.
+func (e *RefEvaluator) force(t *refThunk) refValue {
+	if t.val != nil {
+		return *t.val
+	}
+	if t.forcing {
+		e.failf(t.expr.Pos(), "%w: %v depends on its own value", ErrLoop, t.expr)
+	}
+	t.forcing = true
+	defer func() { t.forcing = false }()
+	val := e.eval(t.expr, t.env)
+	t.val = &val
+	return val
+}
+
+// construct returns the value of constructor spec applied to args. If there
+// are fewer args than fields, it returns a function that takes the rest.
+func (e *RefEvaluator) construct(spec *conSpec, args []*refThunk) refValue {
+	if len(args) == spec.arity {
+		return refValue{con: &refCon{spec: spec, fields: args}}
+	}
+	return refValue{fn: func(arg *refThunk) refValue {
+		return e.construct(spec, append(args[:len(args):len(args)], arg))
+	}}
+}
+
+// builtin returns the value of builtin op applied to args. If there are fewer
+// args than op takes, it returns a function that takes the rest.
+func (e *RefEvaluator) builtin(pos scanner.Position, op *funcSpec, args []*refThunk) refValue {
+	if len(args) == op.nArg {
+		return e.callBuiltin(pos, op, args)
+	}
+	return refValue{fn: func(arg *refThunk) refValue {
+		return e.builtin(pos, op, append(args[:len(args):len(args)], arg))
+	}}
+}
+
+// callBuiltin evaluates args from left to right, and calls op.
+func (e *RefEvaluator) callBuiltin(pos scanner.Position, op *funcSpec, args []*refThunk) refValue {
+	lits := make([]Literal, len(args))
+	for i, arg := range args {
+		val := e.force(arg)
+		if val.fn != nil || val.con != nil {
+			e.failf(pos, "%v: expect a primitive value", op)
+		}
+		lits[i] = val.lit
+	}
+	var result Literal
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err, ok := r.(error)
+				if !ok {
+					err = errors.New(fmt.Sprint(r))
+				}
+				panic(&refFailure{pos: pos, err: err})
+			}
+		}()
+		result = op.cb(lits...)
+	}()
+	return refFromLiteral(result)
+}
+
+// refFromLiteral converts a Literal returned by a builtin to a value.
+func refFromLiteral(lit Literal) refValue {
+	if lit.typ != LiteralCon {
+		return refValue{lit: lit}
+	}
+	con := &refCon{spec: lit.con.spec, fields: make([]*refThunk, len(lit.con.fields))}
+	for i, f := range lit.con.fields {
+		val := refFromLiteral(f.cl.Literal())
+		con.fields[i] = &refThunk{val: &val}
+	}
+	return refValue{con: con}
+}
+
+// match checks if the value of t matches the pattern. If so, it returns env
+// extended with the variables bound by the pattern. The patterns of the fields
+// are matched from left to right.
+func (e *RefEvaluator) match(pat ASTPattern, t *refThunk, env *refEnv) (*refEnv, bool) {
+	switch p := pat.(type) {
+	case *ASTPatWildcard:
+		return env, true
+	case *ASTPatVar:
+		return &refEnv{sym: p.Sym, val: t, next: env}, true
+	case *ASTPatLiteral:
+		val := e.force(t)
+		return env, val.fn == nil && val.con == nil && matchLiteral(val.lit, p.Val)
+	case *ASTPatCon:
+		spec, ok := e.cons[p.Sym]
+		if !ok {
+			e.failf(p.pos, "constructor %v not found", p.Sym)
+		}
+		val := e.force(t)
+		if val.con == nil || val.con.spec != spec {
+			return nil, false
+		}
+		if len(p.Args) != spec.arity {
+			e.failf(p.pos, "constructor %v takes %d arguments, but the pattern has %d", p.Sym, spec.arity, len(p.Args))
+		}
+		for i, arg := range p.Args {
+			if env, ok = e.match(arg, val.con.fields[i], env); !ok {
+				return nil, false
+			}
+		}
+		return env, true
+	}
+	panic(pat)
+}
+
+// literal converts a value to a Literal, evaluating the fields of constructor
+// values. Done maps the constructor values converted so far to the results, so
+// that a cyclic value becomes a cyclic Literal.
+func (e *RefEvaluator) literal(val refValue, done map[*refCon]*conValue) Literal {
+	switch {
+	case val.fn != nil:
+		return Literal{typ: LiteralFunc}
+	case val.con == nil:
+		return val.lit
+	}
+	if c, ok := done[val.con]; ok {
+		return Literal{typ: LiteralCon, con: c}
+	}
+	c := &conValue{spec: val.con.spec, fields: make([]*kVarEntry, len(val.con.fields))}
+	done[val.con] = c
+	for i, f := range val.con.fields {
+		field := e.literal(e.force(f), done)
+		c.fields[i] = &kVarEntry{sym: InternSymbol(fmt.Sprintf("$%d", i)), cl: valueClosure(field)}
+	}
+	return Literal{typ: LiteralCon, con: c}
+}
@@ -0,0 +1,299 @@
+package minifp_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+func TestRefEvaluator(t *testing.T) {
+	ref := minifp.NewRefEvaluator()
+	eval := func(src string) (string, error) {
+		var (
+			val minifp.Literal
+			err error
+		)
+		for _, node := range minifp.Parse(strings.NewReader(src)) {
+			if val, err = ref.Eval(node); err != nil {
+				return "", err
+			}
+		}
+		return val.String(), nil
+	}
+	for _, test := range []struct{ src, want string }{
+		{`1 + 2 * 3`, "7"},
+		{`(\x y -> x - y) 10 3`, "7"},
+		{`\x -> x`, "<function>"},
+		{`letrec fact n = if (n == 0) 1 (n * fact (n - 1)) in fact 20`, "2432902008176640000"},
+		{`take 3 (iterate (\x -> x * 2) 1)`, "[1, 2, 4]"},
+		{`map (\p -> case p of {Pair a b -> a + b}) (zip [1, 2] [10, 20])`, "[11, 22]"},
+		{`case [1, 2, 3] of {x : (y : _) -> x + y; _ -> 0}`, "3"},
+		{`const 1 (1 / 0)`, "1"},
+		{`letrec xs = 1 : 2 : xs in xs`, "1 : 2 : ..."},
+		{`data Tree = Leaf | Node Tree Int Tree; Node Leaf (strlen "abc") (Node Leaf 4 Leaf)`, "Node Leaf 3 (Node Leaf 4 Leaf)"},
+		{`toChars "ab"`, `['a', 'b']`},
+		{`fromChars (toChars "hello")`, `"hello"`},
+		{`sq x = x * x; sq 12`, "144"},
+		{`sq (sq 3)`, "81"},
+	} {
+		got, err := eval(test.src)
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, got, test.want, test.src)
+	}
+
+	_, err := eval(`1 / 0`)
+	expect.True(t, errors.Is(err, minifp.ErrDivisionByZero), err)
+	_, err = eval(`case [] of {x : _ -> x}`)
+	expect.HasSubstr(t, err.Error(), "non-exhaustive patterns in case")
+	_, err = eval(`letrec x = x + 1 in x`)
+	expect.True(t, errors.Is(err, minifp.ErrLoop), err)
+
+	ref.MaxSteps = 1000
+	_, err = eval(`letrec f x = f (x + 1) in f 0`)
+	expect.True(t, errors.Is(err, minifp.ErrStepLimit), err)
+	// The evaluator remains usable after an error.
+	got, err := eval(`sq 5`)
+	expect.NoError(t, err)
+	expect.EQ(t, got, "25")
+}
+
+// genType is the type of a generated expression.
+type genType int
+
+const (
+	genInt  genType = iota
+	genBool         // Bool
+	genList         // [Int]
+	genFun          // Int -> Int
+)
+
+// progGen generates random well-typed programs. Infinite lists, recursive
+// functions, and division by zero make some of them fail or diverge.
+type progGen struct {
+	rnd *rand.Rand
+	// vars are the variables in scope.
+	vars []genVar
+	// nVars is used to generate variable names.
+	nVars int
+}
+
+type genVar struct {
+	name string
+	typ  genType
+}
+
+// program generates an expression of a random type.
+func (g *progGen) program(depth int) string {
+	return g.expr(genType(g.rnd.Intn(3)), depth)
+}
+
+// bind generates an expression by calling fn with a new variable of type t in
+// scope. Sometimes the variable shadows an existing one.
+func (g *progGen) bind(t genType, fn func(name string) string) string {
+	var name string
+	if vars := g.varsOf(t); len(vars) > 0 && g.rnd.Intn(4) == 0 {
+		name = vars[g.rnd.Intn(len(vars))]
+	} else {
+		g.nVars++
+		name = fmt.Sprintf("v%d", g.nVars)
+	}
+	g.vars = append(g.vars, genVar{name, t})
+	defer func() { g.vars = g.vars[:len(g.vars)-1] }()
+	return fn(name)
+}
+
+// varsOf returns the names of the variables of type t that are in scope and
+// not shadowed.
+func (g *progGen) varsOf(t genType) []string {
+	var names []string
+	seen := map[string]bool{}
+	for i := len(g.vars) - 1; i >= 0; i-- {
+		v := g.vars[i]
+		if !seen[v.name] && v.typ == t {
+			names = append(names, v.name)
+		}
+		seen[v.name] = true
+	}
+	return names
+}
+
+// leaf generates an expression without subexpressions.
+func (g *progGen) leaf(t genType) string {
+	if vars := g.varsOf(t); len(vars) > 0 && g.rnd.Intn(2) == 0 {
+		return vars[g.rnd.Intn(len(vars))]
+	}
+	switch t {
+	case genInt:
+		return fmt.Sprint(g.rnd.Intn(5) - 1)
+	case genBool:
+		return []string{"true", "false"}[g.rnd.Intn(2)]
+	case genList:
+		return []string{"[]", "[1, 2, 3]", "range 0 4"}[g.rnd.Intn(3)]
+	}
+	return []string{`(\x -> x + 1)`, `(\x -> x * 3)`, `negate`}[g.rnd.Intn(3)]
+}
+
+func (g *progGen) expr(t genType, depth int) string {
+	if depth <= 0 || g.rnd.Intn(6) == 0 {
+		return g.leaf(t)
+	}
+	d := depth - 1
+	e := func(t genType) string { return g.expr(t, d) }
+	// Forms that produce any type.
+	switch g.rnd.Intn(8) {
+	case 0:
+		return fmt.Sprintf("(if (%s) (%s) (%s))", e(genBool), e(t), e(t))
+	case 1:
+		return g.bind(t, func(x string) string {
+			return fmt.Sprintf("(letrec %s = %s in %s)", x, e(t), e(t))
+		})
+	case 2:
+		arg := genType(g.rnd.Intn(4))
+		a := e(arg)
+		return g.bind(arg, func(x string) string {
+			return fmt.Sprintf(`((\%s -> %s) (%s))`, x, e(t), a)
+		})
+	case 3:
+		return g.bind(genFun, func(f string) string {
+			body := g.bind(genInt, func(x string) string {
+				return fmt.Sprintf(`\%s -> %s`, x, e(genInt))
+			})
+			return fmt.Sprintf("(letrec %s = %s in %s)", f, body, e(t))
+		})
+	case 4:
+		val, nilCase := e(genList), e(t)
+		return g.bind(genInt, func(x string) string {
+			return g.bind(genList, func(xs string) string {
+				return fmt.Sprintf("(case %s of {[] -> %s; %s : %s -> %s})", val, nilCase, x, xs, e(t))
+			})
+		})
+	}
+	switch t {
+	case genInt:
+		switch g.rnd.Intn(6) {
+		case 0, 1:
+			// One operand of "*" is a constant, so that the numbers don't grow
+			// exponentially in a loop.
+			if g.rnd.Intn(5) == 0 {
+				return fmt.Sprintf("(%s * %d)", e(genInt), g.rnd.Intn(4)-1)
+			}
+			op := []string{"+", "-", "/", "%"}[g.rnd.Intn(4)]
+			return fmt.Sprintf("(%s %s %s)", e(genInt), op, e(genInt))
+		case 2:
+			return fmt.Sprintf("(%s (%s))", e(genFun), e(genInt))
+		case 3:
+			return fmt.Sprintf("(sum (%s))", e(genList))
+		case 4:
+			return fmt.Sprintf("(length (%s))", e(genList))
+		}
+		return fmt.Sprintf("(foldl (\\a b -> a + b) (%s) (%s))", e(genInt), e(genList))
+	case genBool:
+		switch g.rnd.Intn(4) {
+		case 0:
+			op := []string{"<", "<=", "==", "!="}[g.rnd.Intn(4)]
+			return fmt.Sprintf("(%s %s %s)", e(genInt), op, e(genInt))
+		case 1:
+			return fmt.Sprintf("(%s && %s)", e(genBool), e(genBool))
+		case 2:
+			return fmt.Sprintf("(%s || %s)", e(genBool), e(genBool))
+		}
+		return fmt.Sprintf("(not (%s))", e(genBool))
+	case genList:
+		switch g.rnd.Intn(8) {
+		case 0:
+			return fmt.Sprintf("(%s : %s)", e(genInt), e(genList))
+		case 1:
+			return fmt.Sprintf("[%s, %s]", e(genInt), e(genInt))
+		case 2:
+			return fmt.Sprintf("(map %s (%s))", e(genFun), e(genList))
+		case 3:
+			return fmt.Sprintf("(take (%s) (%s))", e(genInt), e(genList))
+		case 4:
+			return fmt.Sprintf("(iterate %s (%s))", e(genFun), e(genInt))
+		case 5:
+			pred := g.bind(genInt, func(x string) string {
+				return fmt.Sprintf(`(\%s -> %s)`, x, e(genBool))
+			})
+			return fmt.Sprintf("(filter %s (%s))", pred, e(genList))
+		case 6:
+			return g.bind(genList, func(xs string) string {
+				return fmt.Sprintf("(letrec %s = %s : %s in %s)", xs, e(genInt), xs, xs)
+			})
+		}
+		return fmt.Sprintf("(range (%s) (%s))", e(genInt), e(genInt))
+	}
+	switch g.rnd.Intn(2) {
+	case 0:
+		return g.bind(genInt, func(x string) string {
+			return fmt.Sprintf(`(\%s -> %s)`, x, e(genInt))
+		})
+	}
+	return fmt.Sprintf("(compose %s %s)", e(genFun), e(genFun))
+}
+
+// TestDifferential runs random programs on the machine and on RefEvaluator, and
+// checks that they agree. Each evaluator runs under a step budget; a program
+// that exceeds it is considered to diverge.
+func TestDifferential(t *testing.T) {
+	const (
+		refSteps     = 2000
+		machineSteps = 20000
+	)
+	n := 2000
+	if testing.Short() {
+		n = 300
+	}
+	var (
+		g   = progGen{rnd: rand.New(rand.NewSource(1))}
+		km  = minifp.NewMachine(minifp.MaxSteps(machineSteps))
+		ref = minifp.NewRefEvaluator()
+		tc  = minifp.NewTypeChecker()
+
+		nValues, nErrors, nDiverged int
+	)
+	ref.MaxSteps = refSteps
+	for i := 0; i < n; i++ {
+		src := g.program(5)
+		node := minifp.Parse(strings.NewReader(src))[0]
+		if _, err := tc.Check(node); err != nil {
+			t.Errorf("%s: %v", src, err)
+			continue
+		}
+		want, wantErr := ref.Eval(node)
+		got, gotErr := km.RunErr(km.Compile(node))
+		diverged := func(err error) bool {
+			return errors.Is(err, minifp.ErrStepLimit) || errors.Is(err, minifp.ErrLoop)
+		}
+		switch {
+		case wantErr == nil:
+			nValues++
+			if gotErr != nil {
+				t.Errorf("%s: machine failed: %v; want %v", src, gotErr, want)
+				continue
+			}
+			expect.EQ(t, got.String(), want.String(), src)
+		case diverged(wantErr):
+			// The machine may report a different error first, e.g., for "(1 / 0) +
+			// loop" vs. "loop + (1 / 0)" in a list.
+			nDiverged++
+			if gotErr == nil {
+				t.Errorf("%s: machine returned %v; want divergence: %v", src, got, wantErr)
+			}
+		default:
+			nErrors++
+			if gotErr == nil {
+				t.Errorf("%s: machine returned %v; want error: %v", src, got, wantErr)
+			} else if errors.Is(wantErr, minifp.ErrDivisionByZero) != errors.Is(gotErr, minifp.ErrDivisionByZero) && !diverged(gotErr) {
+				t.Errorf("%s: machine error: %v; want %v", src, gotErr, wantErr)
+			}
+		}
+	}
+	t.Logf("%d values, %d errors, %d diverged", nValues, nErrors, nDiverged)
+	expect.GT(t, nValues, n/4)
+	expect.GT(t, nDiverged, 0)
+}
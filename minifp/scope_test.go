@@ -0,0 +1,139 @@
+package minifp_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+// scopeVars are the names used by the generated programs. There are only two,
+// so that nested binders often shadow each other.
+var scopeVars = []string{"x", "y"}
+
+// termGen enumerates the closed programs of a given size.
+type termGen struct {
+	memo map[string][]string
+}
+
+// terms returns the programs of exactly the given size whose free variables
+// are in scope. The size is the number of constants, variables, and
+// constructs.
+func (g *termGen) terms(size int, scope map[string]bool) []string {
+	key := fmt.Sprint(size, scope["x"], scope["y"])
+	if r, ok := g.memo[key]; ok {
+		return r
+	}
+	var r []string
+	if size == 1 {
+		r = append(r, "1", "2")
+		for _, v := range scopeVars {
+			if scope[v] {
+				r = append(r, v)
+			}
+		}
+	}
+	with := func(v string) map[string]bool {
+		s := map[string]bool{v: true}
+		for k := range scope {
+			s[k] = true
+		}
+		return s
+	}
+	if size >= 2 {
+		for _, v := range scopeVars {
+			for _, body := range g.terms(size-1, with(v)) {
+				r = append(r, fmt.Sprintf(`(\%s -> %s)`, v, body))
+			}
+		}
+	}
+	for n := 1; n+1 < size; n++ {
+		for _, a := range g.terms(n, scope) {
+			for _, b := range g.terms(size-1-n, scope) {
+				r = append(r, fmt.Sprintf("(%s %s)", a, b), fmt.Sprintf("(%s + %s)", a, b))
+			}
+		}
+		for _, v := range scopeVars {
+			inner := with(v)
+			for _, a := range g.terms(n, inner) {
+				for _, b := range g.terms(size-1-n, inner) {
+					r = append(r, fmt.Sprintf("(letrec %s = %s in %s)", v, a, b))
+				}
+			}
+		}
+	}
+	for n := 1; n+2 < size; n++ {
+		inner := with("x")
+		inner["y"] = true
+		for _, a := range g.terms(n, inner) {
+			for _, b := range g.terms(size-2-n, inner) {
+				r = append(r, fmt.Sprintf("(letrec x = %s; y = %s in x + y)", a, b))
+			}
+		}
+	}
+	g.memo[key] = r
+	return r
+}
+
+// TestScopes compares the machine against RefEvaluator, which looks up
+// variables by name, on every well-typed program up to a given size. The
+// programs nest lambdas and letrecs in all combinations, with shadowing.
+func TestScopes(t *testing.T) {
+	maxSize := 6
+	if testing.Short() {
+		maxSize = 5
+	}
+	var (
+		km  = minifp.NewMachine(minifp.NoPrelude(), minifp.MaxSteps(10000))
+		tc  = minifp.NewTypeChecker()
+		ref = minifp.NewRefEvaluator()
+		g   = termGen{memo: map[string][]string{}}
+
+		nChecked, nDiverged int
+	)
+	ref.MaxSteps = 1000
+	for size := 1; size <= maxSize; size++ {
+		for _, src := range g.terms(size, map[string]bool{}) {
+			node := minifp.Parse(strings.NewReader(src))[0]
+			if _, err := tc.Check(node); err != nil {
+				continue
+			}
+			want, err := ref.Eval(node)
+			if err != nil {
+				if !errors.Is(err, minifp.ErrStepLimit) && !errors.Is(err, minifp.ErrLoop) {
+					t.Errorf("%s: %v", src, err)
+				}
+				nDiverged++
+				continue
+			}
+			val, err := km.RunErr(km.Compile(node))
+			if err != nil {
+				t.Errorf("%s: %v, want %s", src, err, want)
+				continue
+			}
+			expect.EQ(t, val.String(), want.String(), src)
+			nChecked++
+		}
+	}
+	t.Logf("checked %d programs, %d diverged", nChecked, nDiverged)
+	expect.GT(t, nChecked, 1000)
+}
+
+func TestShadowing(t *testing.T) {
+	km := minifp.NewMachine()
+	for _, test := range []struct{ src, want string }{
+		{`(\x -> \x -> x) 1 2`, "2"},
+		{`(\x -> \y -> \x -> y) 1 2 3`, "2"},
+		{`(\x -> letrec x = 10 in x) 1`, "10"},
+		{`letrec x = 1 in (\x -> x) 2`, "2"},
+		{`letrec x = 1 in letrec f = \y -> x + y; x = 100 in f 1`, "101"},
+		{`letrec f = \x -> letrec g = \x -> x + 1 in g (x * 10) in f 2`, "21"},
+		{`(\x -> case [x + 1] of {x : _ -> x}) 1`, "2"},
+		{`(\x -> case Pair 5 x of {Pair x y -> x + y}) 1`, "6"},
+	} {
+		expect.EQ(t, run(t, km, test.src).String(), test.want, test.src)
+	}
+}
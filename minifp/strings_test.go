@@ -0,0 +1,102 @@
+package minifp_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+func TestStringLiteral(t *testing.T) {
+	km := minifp.NewMachine()
+	expect.EQ(t, run(t, km, `"hello"`).String(), `"hello"`)
+	expect.EQ(t, run(t, km, `"a\tb\n\"c\""`).String(), `"a\tb\n\"c\""`)
+	expect.EQ(t, run(t, km, "`raw\\n`").String(), `"raw\\n"`)
+	expect.EQ(t, run(t, km, `'x'`).String(), `'x'`)
+	expect.EQ(t, run(t, km, `'\n'`).String(), `'\n'`)
+	expect.EQ(t, run(t, km, `'世'`).String(), `'世'`)
+	expect.EQ(t, run(t, km, `["a", "b"]`).String(), `["a", "b"]`)
+
+	_, err := minifp.ParseErr(strings.NewReader(`"abc`))
+	var synErr *minifp.SyntaxError
+	expect.True(t, errors.As(err, &synErr))
+	expect.HasSubstr(t, err.Error(), "literal not terminated")
+}
+
+func TestStringBuiltins(t *testing.T) {
+	km := minifp.NewMachine()
+	for _, test := range []struct{ src, want string }{
+		{`"foo" ++ "bar" ++ ""`, `"foobar"`},
+		{`strlen "héllo"`, "5"},
+		{`strlen ""`, "0"},
+		{`substr "héllo" 1 3`, `"éll"`},
+		{`substr "hello" 3 10`, `"lo"`},
+		{`substr "hello" 10 1`, `""`},
+		{`"abc" == "abc"`, "true"},
+		{`"abc" < "abd"`, "true"},
+		{`"b" >= "abc"`, "true"},
+		{`'a' < 'b'`, "true"},
+		{`show 42`, `"42"`},
+		{`show (1 == 2)`, `"false"`},
+		{`"n=" ++ show (1 + 2)`, `"n=3"`},
+		{`toChars "héy"`, `['h', 'é', 'y']`},
+		{`fromChars ['o', 'k']`, `"ok"`},
+		{`fromChars (map (\c -> chr (ord c + 1)) (toChars "HAL"))`, `"IBM"`},
+		{`length (toChars "abc")`, "3"},
+		{`case "yes" of {"no" -> 0; "yes" -> 1; _ -> 2}`, "1"},
+		{`case 'b' of {'a' -> 0; 'b' -> 1; _ -> 2}`, "1"},
+	} {
+		expect.EQ(t, run(t, km, test.src).String(), test.want, test.src)
+	}
+
+	// A global shadows a builtin of the same name.
+	expect.EQ(t, run(t, km, `strlen s = 100; strlen "abc"`).String(), "100")
+
+	// The type checker rejects this, but the machine doesn't check types.
+	x := minifp.Parse(strings.NewReader(`"a" ++ show []`))
+	_, err := km.RunErr(km.Compile(x[0]))
+	expect.HasSubstr(t, err.Error(), ": show: cannot show []")
+	expect.False(t, strings.Contains(err.Error(), "show: show:"))
+}
+
+func TestStringTypes(t *testing.T) {
+	for _, test := range []struct{ src, want string }{
+		{`"abc"`, "String"},
+		{`'a'`, "Char"},
+		{`\s -> s ++ "!"`, "String -> String"},
+		{`strlen`, "String -> Int"},
+		{`substr`, "String -> Int -> Int -> String"},
+		{`toChars`, "String -> [Char]"},
+		{`fromChars`, "[Char] -> String"},
+		{`show`, "a -> String"},
+		{`\x -> show x ++ show (x < x)`, "a -> String"},
+		{`\x y -> x == y`, "a -> a -> Bool"},
+		{`"a" < "b"`, "Bool"},
+	} {
+		got, err := typeOf(t, test.src)
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, got, test.want, test.src)
+	}
+	for _, test := range []struct{ src, want string }{
+		{`"a" ++ 1`, "type mismatch: expect String, but found Int"},
+		{`"a" == 'a'`, "type mismatch: expect String, but found Char"},
+		{`strlen 'a'`, "type mismatch: expect String, but found Char"},
+		// Only the base types can be compared.
+		{`[1] == [1]`, "type [Int] cannot be compared"},
+		{`"a" : [] < []`, "type [String] cannot be compared"},
+		{`data Color = Red | Green; Red != Green`, "type Color cannot be compared"},
+		{`Pair 1 2 == Pair 1 2`, "type Pair Int Int cannot be compared"},
+		{`(\x -> x) == (\x -> x)`, "cannot be compared"},
+		{`eq x y = x == y; eq [1] [2]`, "type [Int] cannot be compared"},
+		{`data Maybe a = Nothing | Just a; Just 1 < Nothing`, "type Maybe Int cannot be compared"},
+		{`show [1]`, "type [Int] cannot be compared"},
+	} {
+		_, err := typeOf(t, test.src)
+		expect.True(t, err != nil, test.src)
+		if err != nil {
+			expect.HasSubstr(t, err.Error(), test.want, test.src)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package minifp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Tracer observes the execution of a KMachine. It is installed by WithTracer or
+// KMachine.SetTracer. The methods are called synchronously from the machine
+// loop, so they should be cheap.
+type Tracer interface {
+	// OnStep is called before each step. Code is the instruction about to be
+	// executed, and env and stack are the machine's local environment and stack.
+	OnStep(step int, code KCode, env TraceEnv, stack TraceStack)
+	// OnReturn is called when an expression evaluates to a value.
+	OnReturn(step int, val Literal)
+	// OnUpdate is called when the thunk of variable sym is overwritten with its
+	// value.
+	OnUpdate(step int, sym Symbol, val KClosure)
+}
+
+// TraceEnv is a read-only view of a local environment passed to a Tracer.
+type TraceEnv struct{ f *kEnvFrame }
+
+func (e TraceEnv) String() string { return e.f.String() }
+
+// TraceStack is a read-only view of the machine stack passed to a Tracer. The
+// last element is the top of the stack. It must not be retained after the
+// Tracer method returns.
+type TraceStack []kStackEntry
+
+func (s TraceStack) String() string { return fmt.Sprint([]kStackEntry(s)) }
+
+// strings renders each stack entry.
+func (s TraceStack) strings() []string {
+	r := make([]string, len(s))
+	for i, e := range s {
+		r[i] = e.String()
+	}
+	return r
+}
+
+// WithTracer installs a tracer on the machine. By default, the machine runs
+// without a tracer.
+func WithTracer(t Tracer) Option { return func(k *KMachine) { k.tracer = t } }
+
+// SetTracer installs a tracer on the machine. A nil tracer disables tracing.
+func (k *KMachine) SetTracer(t Tracer) { k.tracer = t }
+
+// NopTracer is a Tracer that does nothing.
+type NopTracer struct{}
+
+func (NopTracer) OnStep(int, KCode, TraceEnv, TraceStack) {}
+func (NopTracer) OnReturn(int, Literal)                   {}
+func (NopTracer) OnUpdate(int, Symbol, KClosure)          {}
+
+type textTracer struct {
+	NopTracer
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewTextTracer creates a Tracer that prints one line per step to out, in the
+// form "step: code env stack".
+func NewTextTracer(out io.Writer) Tracer {
+	return &textTracer{out: out}
+}
+
+func (t *textTracer) OnStep(step int, code KCode, env TraceEnv, stack TraceStack) {
+	t.mu.Lock()
+	fmt.Fprintf(t.out, "%d: %v %v %v\n", step, code.DebugString(), env, stack)
+	t.mu.Unlock()
+}
+
+// jsonTraceEvent is one line of the output of the tracer created by
+// NewJSONTracer.
+type jsonTraceEvent struct {
+	Event string   `json:"event"`
+	Step  int      `json:"step"`
+	Code  string   `json:"code,omitempty"`
+	Pos   string   `json:"pos,omitempty"`
+	Env   string   `json:"env,omitempty"`
+	Stack []string `json:"stack,omitempty"`
+	Sym   string   `json:"sym,omitempty"`
+	Value string   `json:"value,omitempty"`
+}
+
+type jsonTracer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONTracer creates a Tracer that writes one JSON object per event to
+// out. Each object has an "event" field, which is one of "step", "return", or
+// "update", and a "step" field. The other fields depend on the event type.
+func NewJSONTracer(out io.Writer) Tracer {
+	return &jsonTracer{enc: json.NewEncoder(out)}
+}
+
+func (t *jsonTracer) emit(ev jsonTraceEvent) {
+	t.mu.Lock()
+	// Write errors are ignored; tracing must not affect the evaluation.
+	_ = t.enc.Encode(ev)
+	t.mu.Unlock()
+}
+
+func (t *jsonTracer) OnStep(step int, code KCode, env TraceEnv, stack TraceStack) {
+	ev := jsonTraceEvent{
+		Event: "step",
+		Step:  step,
+		Code:  code.DebugString(),
+		Env:   env.String(),
+		Stack: stack.strings(),
+	}
+	if pos := code.Pos(); pos.IsValid() {
+		ev.Pos = pos.String()
+	}
+	t.emit(ev)
+}
+
+func (t *jsonTracer) OnReturn(step int, val Literal) {
+	t.emit(jsonTraceEvent{Event: "return", Step: step, Value: val.String()})
+}
+
+func (t *jsonTracer) OnUpdate(step int, sym Symbol, val KClosure) {
+	t.emit(jsonTraceEvent{Event: "update", Step: step, Sym: sym.String(), Value: val.String()})
+}
@@ -0,0 +1,900 @@
+package minifp
+
+import (
+	"fmt"
+	"strings"
+	"text/scanner"
+)
+
+// Type is the type of a minifp expression, as inferred by TypeChecker.
+type Type interface {
+	String() string
+}
+
+// TypeCon is a type constructor applied to zero or more arguments, e.g., Int,
+// or a -> b, whose Name is "->".
+type TypeCon struct {
+	Name string
+	Args []Type
+}
+
+// TypeVar is a type variable. The type checker binds variables during
+// unification; the types returned by TypeChecker contain only unbound variables.
+type TypeVar struct {
+	Name string
+	// link is the type that this variable is bound to, or nil.
+	link Type
+	// level is the letrec nesting depth at which the variable was created. It is
+	// genericLevel for a quantified variable.
+	level int
+	// numeric is true if the variable can be bound only to Int or Float. It is
+	// the type of an integer literal and of the arithmetic operators. A numeric
+	// variable is generalized like the others, so "double x = x + x" can be
+	// applied to an Int or a Float. An unbound numeric variable in the type of a
+	// toplevel expression defaults to Int, and an unnamed one is rendered as
+	// Int.
+	numeric bool
+	// ordered is true if the variable can be bound only to a type whose values
+	// the comparison operators can compare: Int, Float, Bool, String, or Char. A
+	// numeric variable is also ordered.
+	ordered bool
+}
+
+const genericLevel = int(^uint(0) >> 1)
+
+var (
+	tInt    = &TypeCon{Name: "Int"}
+	tFloat  = &TypeCon{Name: "Float"}
+	tBool   = &TypeCon{Name: "Bool"}
+	tString = &TypeCon{Name: "String"}
+	tChar   = &TypeCon{Name: "Char"}
+)
+
+func newFuncType(arg, ret Type) Type { return &TypeCon{Name: "->", Args: []Type{arg, ret}} }
+
+// prune follows the links of bound type variables.
+func prune(t Type) Type {
+	for {
+		v, ok := t.(*TypeVar)
+		if !ok || v.link == nil {
+			return t
+		}
+		t = v.link
+	}
+}
+
+func (t *TypeCon) String() string { return typeString(t, &typeNamer{}) }
+func (t *TypeVar) String() string { return typeString(t, &typeNamer{}) }
+
+// typeNamer assigns names a, b, c, ... to anonymous type variables, and num,
+// num1, num2, ... to numeric ones.
+type typeNamer struct {
+	names map[*TypeVar]string
+	nNum  int
+}
+
+func (n *typeNamer) name(v *TypeVar) string {
+	if v.Name != "" {
+		return v.Name
+	}
+	if n.names == nil {
+		n.names = map[*TypeVar]string{}
+	}
+	name, ok := n.names[v]
+	if !ok {
+		if v.numeric {
+			name = "num"
+			if n.nNum > 0 {
+				name += fmt.Sprint(n.nNum)
+			}
+			n.nNum++
+		} else {
+			i := len(n.names) - n.nNum
+			name = string(rune('a' + i%26))
+			if i >= 26 {
+				name += fmt.Sprint(i / 26)
+			}
+		}
+		n.names[v] = name
+	}
+	return name
+}
+
+func typeString(t Type, n *typeNamer) string {
+	var buf strings.Builder
+	writeType(&buf, t, n, typePrecTop)
+	return buf.String()
+}
+
+// Contexts in which writeType renders a type. They determine whether the type
+// needs parentheses.
+const (
+	typePrecTop   = iota
+	typePrecArrow // the argument of "->"
+	typePrecArg   // an argument of a type constructor
+)
+
+func writeType(buf *strings.Builder, t Type, n *typeNamer, prec int) {
+	switch v := prune(t).(type) {
+	case *TypeVar:
+		if v.numeric && v.Name == "" {
+			buf.WriteString(tInt.Name)
+			return
+		}
+		buf.WriteString(n.name(v))
+	case *TypeCon:
+		if len(v.Args) == 0 {
+			buf.WriteString(v.Name)
+			return
+		}
+		if v.Name == listTypeName && len(v.Args) == 1 {
+			buf.WriteRune('[')
+			writeType(buf, v.Args[0], n, typePrecTop)
+			buf.WriteRune(']')
+			return
+		}
+		paren := prec == typePrecArg || (prec == typePrecArrow && v.Name == "->")
+		if paren {
+			buf.WriteRune('(')
+		}
+		if v.Name == "->" {
+			writeType(buf, v.Args[0], n, typePrecArrow)
+			buf.WriteString(" -> ")
+			writeType(buf, v.Args[1], n, typePrecTop)
+		} else {
+			buf.WriteString(v.Name)
+			for _, arg := range v.Args {
+				buf.WriteRune(' ')
+				writeType(buf, arg, n, typePrecArg)
+			}
+		}
+		if paren {
+			buf.WriteRune(')')
+		}
+	}
+}
+
+// TypeError is reported by TypeChecker for an ill-typed expression.
+type TypeError struct {
+	Pos scanner.Position
+	Msg string
+}
+
+func (e *TypeError) Error() string { return e.Pos.String() + ": " + e.Msg }
+
+// TypeChecker infers Hindley-Milner types of expressions. Letrec and toplevel
+// bindings are generalized, so they can be used at different types.
+//
+// A TypeChecker remembers the types of toplevel assignments, so a sequence of
+// toplevel expressions should be checked in order using one TypeChecker.
+type TypeChecker struct {
+	globals map[Symbol]Type
+	// typeArity maps the name of a type constructor to its number of arguments.
+	typeArity map[string]int
+	// cons maps a data constructor to its type. Its type is a function from the
+	// fields to the data type, e.g., "a -> Maybe a" for "Just".
+	cons map[Symbol]Type
+	// funcs holds the functions registered on the machine passed to
+	// DeclareFuncs.
+	funcs map[string]*funcSpec
+	// noPrelude is set by NoPreludeTypes.
+	noPrelude bool
+}
+
+// CheckerOption configures a TypeChecker.
+type CheckerOption func(c *TypeChecker)
+
+// typeEnv is a linked list of local variable types.
+type typeEnv struct {
+	sym  Symbol
+	typ  Type
+	next *typeEnv
+}
+
+// NewTypeChecker creates a type checker that knows the types of the functions
+// defined in the prelude, unless NoPreludeTypes is given.
+func NewTypeChecker(opts ...CheckerOption) *TypeChecker {
+	c := newTypeChecker()
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.noPrelude {
+		return c
+	}
+	p := checkedPrelude()
+	// The types are generalized, so they are never modified by unification, and
+	// they can be shared.
+	for sym, t := range p.globals {
+		c.globals[sym] = t
+	}
+	for name, n := range p.typeArity {
+		c.typeArity[name] = n
+	}
+	for sym, t := range p.cons {
+		c.cons[sym] = t
+	}
+	return c
+}
+
+// newTypeChecker creates a type checker that knows only the builtin types.
+func newTypeChecker() *TypeChecker {
+	c := &TypeChecker{
+		globals:   map[Symbol]Type{},
+		typeArity: map[string]int{"Int": 0, "Float": 0, "Bool": 0, "String": 0, "Char": 0, listTypeName: 1, pairTypeName: 2},
+		cons:      listConTypes(),
+	}
+	c.cons[pairSpec.sym] = pairConType()
+	return c
+}
+
+// TypeOf infers the type of a standalone expression.
+func TypeOf(node ASTNode) (Type, error) {
+	return NewTypeChecker().Check(node)
+}
+
+// Check infers the type of the expression. If node is an ASTAssign, the type of
+// the variable is recorded for later calls, and the result may contain numeric
+// variables, named num, num1, .... Otherwise, numeric variables in the result
+// default to Int. On error, it returns a *TypeError.
+func (c *TypeChecker) Check(node ASTNode) (typ Type, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*TypeError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	switch v := node.(type) {
+	case *ASTAssign:
+		typ = c.inferRecursive(nil, []*ASTAssign{v}, 0)[0]
+		c.globals[v.Sym] = typ
+		return exportType(typ, false, map[*TypeVar]*TypeVar{}, &typeNamer{}), nil
+	case *ASTData:
+		typ = c.declareData(v)
+	default:
+		typ = c.infer(nil, node, 0)
+	}
+	return exportType(typ, true, map[*TypeVar]*TypeVar{}, &typeNamer{}), nil
+}
+
+// CheckDefs infers the types of a group of toplevel definitions, e.g., those of
+// a script. Unlike a sequence of Check calls, an assignment may refer to those
+// that follow it. The data declarations are checked first. The assignments are
+// then split into groups of mutually recursive ones, which are checked in
+// dependency order, so that a definition is polymorphic in those that use it.
+// It returns the types of the nodes, as Check does. On error, it returns a
+// *TypeError, and the checker is left unchanged.
+func (c *TypeChecker) CheckDefs(nodes []ASTNode) (types []Type, err error) {
+	globals, typeArity, cons := c.globals, c.typeArity, c.cons
+	c.globals = make(map[Symbol]Type, len(globals))
+	for sym, t := range globals {
+		c.globals[sym] = t
+	}
+	c.typeArity = make(map[string]int, len(typeArity))
+	for name, n := range typeArity {
+		c.typeArity[name] = n
+	}
+	c.cons = make(map[Symbol]Type, len(cons))
+	for sym, t := range cons {
+		c.cons[sym] = t
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*TypeError)
+			if !ok {
+				panic(r)
+			}
+			c.globals, c.typeArity, c.cons = globals, typeArity, cons
+			types, err = nil, e
+		}
+	}()
+	types = make([]Type, len(nodes))
+	var assigns []*ASTAssign
+	defined := map[Symbol]bool{}
+	for i, node := range nodes {
+		switch v := node.(type) {
+		case *ASTData:
+			types[i] = exportType(c.declareData(v), true, map[*TypeVar]*TypeVar{}, &typeNamer{})
+		case *ASTAssign:
+			if defined[v.Sym] {
+				c.errorf(v.pos, "%v is defined more than once", v.Sym)
+			}
+			defined[v.Sym] = true
+			assigns = append(assigns, v)
+		default:
+			c.errorf(v.Pos(), "toplevel expression %v is not a definition", v)
+		}
+	}
+	for _, group := range bindingGroups(assigns) {
+		for i, t := range c.inferRecursive(nil, group, 0) {
+			c.globals[group[i].Sym] = t
+		}
+	}
+	for i, node := range nodes {
+		if v, ok := node.(*ASTAssign); ok {
+			types[i] = exportType(c.globals[v.Sym], false, map[*TypeVar]*TypeVar{}, &typeNamer{})
+		}
+	}
+	return types, nil
+}
+
+func (c *TypeChecker) errorf(pos scanner.Position, format string, args ...interface{}) {
+	panic(&TypeError{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *TypeChecker) lookup(env *typeEnv, sym Symbol) (Type, bool) {
+	for e := env; e != nil; e = e.next {
+		if e.sym == sym {
+			return e.typ, true
+		}
+	}
+	if t, ok := c.globals[sym]; ok {
+		return t, true
+	}
+	if op, ok := c.funcs[sym.String()]; ok {
+		return op.sigType, true
+	}
+	if op, ok := funcs[sym.String()]; ok {
+		return op.sigType, true
+	}
+	return nil, false
+}
+
+// DeclareFuncs makes the functions registered on k by RegisterFunc,
+// RegisterFuncSig, and RegisterGoFunc known to the checker, including those
+// registered later.
+func (c *TypeChecker) DeclareFuncs(k *KMachine) { c.funcs = k.funcs }
+
+func (c *TypeChecker) infer(env *typeEnv, node ASTNode, level int) Type {
+	switch v := node.(type) {
+	case *ASTConst:
+		switch v.Val.typ {
+		case LiteralInt, LiteralBigInt:
+			// An integer literal can also be used as a float.
+			return &TypeVar{level: level, numeric: true}
+		case LiteralFloat:
+			return tFloat
+		case LiteralBool:
+			return tBool
+		case LiteralString:
+			return tString
+		case LiteralChar:
+			return tChar
+		}
+		c.errorf(v.pos, "constant %v has no type", v.Val)
+	case *ASTVar:
+		t, ok := c.lookup(env, v.Sym)
+		if !ok {
+			c.errorf(v.pos, "variable %v not found", v.Sym)
+		}
+		return instantiate(t, level)
+	case *ASTLambda:
+		arg := &TypeVar{level: level}
+		body := c.infer(&typeEnv{sym: v.Arg, typ: arg, next: env}, v.Body, level)
+		return newFuncType(arg, body)
+	case *ASTApply:
+		fn := c.infer(env, v.Head, level)
+		arg := c.infer(env, v.Tail, level)
+		if fn, ok := prune(fn).(*TypeCon); ok && fn.Name == "->" {
+			c.unify(v.Tail.Pos(), fn.Args[0], arg)
+			return fn.Args[1]
+		}
+		ret := &TypeVar{level: level}
+		c.unify(v.Head.Pos(), newFuncType(arg, ret), fn)
+		return ret
+	case *ASTApplyLeafFunction:
+		t := instantiate(v.Op.sigType, level)
+		for _, argNode := range v.Args {
+			fn := t.(*TypeCon)
+			c.unify(argNode.Pos(), fn.Args[0], c.infer(env, argNode, level))
+			t = fn.Args[1]
+		}
+		return t
+	case *ASTIf:
+		c.unify(v.Cond.Pos(), tBool, c.infer(env, v.Cond, level))
+		t := c.infer(env, v.Then, level)
+		c.unify(v.Else.Pos(), t, c.infer(env, v.Else, level))
+		return t
+	case *ASTLetrec:
+		types := c.inferRecursive(env, v.Bindings, level)
+		for i, b := range v.Bindings {
+			env = &typeEnv{sym: b.Sym, typ: types[i], next: env}
+		}
+		return c.infer(env, v.Body, level)
+	case *ASTCon:
+		t, ok := c.cons[v.Sym]
+		if !ok {
+			c.errorf(v.pos, "constructor %v not found", v.Sym)
+		}
+		return instantiate(t, level)
+	case *ASTCase:
+		scrutinee := c.infer(env, v.Expr, level)
+		result := &TypeVar{level: level}
+		for _, alt := range v.Alts {
+			altEnv := c.inferPattern(env, alt.Pat, scrutinee, level)
+			c.unify(alt.Body.Pos(), result, c.infer(altEnv, alt.Body, level))
+		}
+		return result
+	case *ASTAssign:
+		c.errorf(v.pos, "assignment to %v is allowed only at toplevel", v.Sym)
+	case *ASTData:
+		c.errorf(v.pos, "data declaration %v is allowed only at toplevel", v.Sym)
+	case *ASTModule, *ASTImport:
+		c.errorf(v.Pos(), "%v must be resolved by a ModuleLoader", v)
+	}
+	panic(node)
+}
+
+// inferPattern unifies the type of the pattern with typ, and returns env
+// extended with the variables bound by the pattern.
+func (c *TypeChecker) inferPattern(env *typeEnv, pat ASTPattern, typ Type, level int) *typeEnv {
+	switch p := pat.(type) {
+	case *ASTPatVar:
+		return &typeEnv{sym: p.Sym, typ: typ, next: env}
+	case *ASTPatWildcard:
+		return env
+	case *ASTPatLiteral:
+		c.unify(p.pos, typ, c.infer(nil, &ASTConst{pos: p.pos, Val: p.Val}, level))
+		return env
+	case *ASTPatCon:
+		t, ok := c.cons[p.Sym]
+		if !ok {
+			c.errorf(p.pos, "constructor %v not found", p.Sym)
+		}
+		t = instantiate(t, level)
+		for _, arg := range p.Args {
+			fn, ok := t.(*TypeCon)
+			if !ok || fn.Name != "->" {
+				c.errorf(p.pos, "constructor %v is applied to too many arguments", p.Sym)
+			}
+			env = c.inferPattern(env, arg, fn.Args[0], level)
+			t = fn.Args[1]
+		}
+		if fn, ok := t.(*TypeCon); ok && fn.Name == "->" {
+			c.errorf(p.pos, "constructor %v is applied to too few arguments", p.Sym)
+		}
+		c.unify(p.pos, typ, t)
+		return env
+	}
+	panic(pat)
+}
+
+// declareData records the types of the constructors declared by the node, and
+// returns the declared type.
+func (c *TypeChecker) declareData(v *ASTData) Type {
+	name := v.Sym.String()
+	switch name {
+	case "Int", "Float", "Bool", "String", "Char":
+		c.errorf(v.pos, "cannot redefine builtin type %v", name)
+	}
+	c.typeArity[name] = len(v.Params)
+	params := map[string]*TypeVar{}
+	result := &TypeCon{Name: name}
+	for _, p := range v.Params {
+		if _, ok := params[p.String()]; ok {
+			c.errorf(v.pos, "duplicate type parameter %v", p)
+		}
+		tv := &TypeVar{level: genericLevel}
+		params[p.String()] = tv
+		result.Args = append(result.Args, tv)
+	}
+	var convert func(pos scanner.Position, t Type) Type
+	convert = func(pos scanner.Position, t Type) Type {
+		switch t := t.(type) {
+		case *TypeVar:
+			tv, ok := params[t.Name]
+			if !ok {
+				c.errorf(pos, "type variable %v not found", t.Name)
+			}
+			return tv
+		case *TypeCon:
+			arity, ok := c.typeArity[t.Name]
+			if t.Name == "->" {
+				arity, ok = 2, true
+			}
+			if !ok {
+				c.errorf(pos, "type %v not found", t.Name)
+			}
+			if arity != len(t.Args) {
+				c.errorf(pos, "type %v takes %d arguments, but found %d", t.Name, arity, len(t.Args))
+			}
+			args := make([]Type, len(t.Args))
+			for i, arg := range t.Args {
+				args[i] = convert(pos, arg)
+			}
+			return &TypeCon{Name: t.Name, Args: args}
+		}
+		panic(t)
+	}
+	for _, decl := range v.Cons {
+		var t Type = result
+		for i := len(decl.Fields) - 1; i >= 0; i-- {
+			t = newFuncType(convert(decl.pos, decl.Fields[i]), t)
+		}
+		c.cons[decl.Sym] = t
+	}
+	return result
+}
+
+// inferRecursive infers the types of mutually recursive bindings and
+// generalizes them.
+func (c *TypeChecker) inferRecursive(env *typeEnv, bindings []*ASTAssign, level int) []Type {
+	types := make([]Type, len(bindings))
+	for i, b := range bindings {
+		types[i] = &TypeVar{level: level + 1}
+		env = &typeEnv{sym: b.Sym, typ: types[i], next: env}
+	}
+	for i, b := range bindings {
+		c.unify(b.pos, types[i], c.infer(env, b.Expr, level+1))
+	}
+	for _, t := range types {
+		generalize(t, level)
+	}
+	return types
+}
+
+// bindingGroups splits toplevel assignments into the strongly connected
+// components of their dependency graph, i.e., groups of mutually recursive
+// assignments. A group is listed after the groups it refers to.
+func bindingGroups(assigns []*ASTAssign) [][]*ASTAssign {
+	index := make(map[Symbol]int, len(assigns))
+	for i, b := range assigns {
+		index[b.Sym] = i
+	}
+	// Tarjan's algorithm emits a component after all the components reachable
+	// from it.
+	var (
+		groups  [][]*ASTAssign
+		stack   []int
+		order   = make([]int, len(assigns)) // 1 + the visit order; 0 if unvisited.
+		low     = make([]int, len(assigns))
+		onStack = make([]bool, len(assigns))
+		n       int
+		visit   func(i int)
+	)
+	visit = func(i int) {
+		n++
+		order[i], low[i] = n, n
+		stack = append(stack, i)
+		onStack[i] = true
+		freeVars(assigns[i].Expr, nil, func(sym Symbol) {
+			j, ok := index[sym]
+			if !ok {
+				return
+			}
+			if order[j] == 0 {
+				visit(j)
+				if low[j] < low[i] {
+					low[i] = low[j]
+				}
+			} else if onStack[j] && order[j] < low[i] {
+				low[i] = order[j]
+			}
+		})
+		if low[i] != order[i] {
+			return
+		}
+		var group []*ASTAssign
+		for {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[j] = false
+			group = append(group, assigns[j])
+			if j == i {
+				break
+			}
+		}
+		groups = append(groups, group)
+	}
+	for i := range assigns {
+		if order[i] == 0 {
+			visit(i)
+		}
+	}
+	return groups
+}
+
+// freeVars calls fn for each reference in node to a variable that is not
+// bound within node or listed in locals.
+func freeVars(node ASTNode, locals []Symbol, fn func(sym Symbol)) {
+	switch v := node.(type) {
+	case *ASTVar:
+		if !symbolIn(v.Sym, locals) {
+			fn(v.Sym)
+		}
+	case *ASTApply:
+		freeVars(v.Head, locals, fn)
+		freeVars(v.Tail, locals, fn)
+	case *ASTLambda:
+		freeVars(v.Body, append(locals, v.Arg), fn)
+	case *ASTApplyLeafFunction:
+		for _, arg := range v.Args {
+			freeVars(arg, locals, fn)
+		}
+	case *ASTLetrec:
+		for _, b := range v.Bindings {
+			locals = append(locals, b.Sym)
+		}
+		for _, b := range v.Bindings {
+			freeVars(b.Expr, locals, fn)
+		}
+		freeVars(v.Body, locals, fn)
+	case *ASTIf:
+		freeVars(v.Cond, locals, fn)
+		freeVars(v.Then, locals, fn)
+		freeVars(v.Else, locals, fn)
+	case *ASTCase:
+		freeVars(v.Expr, locals, fn)
+		for _, alt := range v.Alts {
+			freeVars(alt.Body, patternVars(alt.Pat, locals), fn)
+		}
+	}
+}
+
+// patternVars appends the variables bound by the pattern to locals.
+func patternVars(pat ASTPattern, locals []Symbol) []Symbol {
+	switch p := pat.(type) {
+	case *ASTPatVar:
+		locals = append(locals, p.Sym)
+	case *ASTPatCon:
+		for _, arg := range p.Args {
+			locals = patternVars(arg, locals)
+		}
+	}
+	return locals
+}
+
+func symbolIn(sym Symbol, syms []Symbol) bool {
+	for _, s := range syms {
+		if s == sym {
+			return true
+		}
+	}
+	return false
+}
+
+// generalize quantifies the unbound type variables in t created at a level
+// deeper than the given one.
+func generalize(t Type, level int) {
+	switch v := prune(t).(type) {
+	case *TypeVar:
+		if v.level > level {
+			v.level = genericLevel
+		}
+	case *TypeCon:
+		for _, arg := range v.Args {
+			generalize(arg, level)
+		}
+	}
+}
+
+// instantiate replaces the quantified variables in t with fresh ones.
+func instantiate(t Type, level int) Type {
+	vars := map[*TypeVar]Type{}
+	var visit func(t Type) Type
+	visit = func(t Type) Type {
+		switch v := prune(t).(type) {
+		case *TypeVar:
+			if v.level != genericLevel {
+				return v
+			}
+			nv, ok := vars[v]
+			if !ok {
+				nv = &TypeVar{level: level, numeric: v.numeric, ordered: v.ordered}
+				vars[v] = nv
+			}
+			return nv
+		case *TypeCon:
+			if len(v.Args) == 0 {
+				return v
+			}
+			args := make([]Type, len(v.Args))
+			for i, arg := range v.Args {
+				args[i] = visit(arg)
+			}
+			return &TypeCon{Name: v.Name, Args: args}
+		}
+		panic(t)
+	}
+	return visit(t)
+}
+
+// exportType creates a copy of t that doesn't share type variables with the
+// checker's state. Variables are named a, b, c, ..., and numeric ones num,
+// num1, .... If defaultNum is true, numeric variables become Int instead.
+func exportType(t Type, defaultNum bool, vars map[*TypeVar]*TypeVar, n *typeNamer) Type {
+	switch v := prune(t).(type) {
+	case *TypeVar:
+		if v.numeric && defaultNum {
+			return tInt
+		}
+		nv, ok := vars[v]
+		if !ok {
+			nv = &TypeVar{Name: n.name(v), level: genericLevel, numeric: v.numeric, ordered: v.ordered}
+			vars[v] = nv
+		}
+		return nv
+	case *TypeCon:
+		if len(v.Args) == 0 {
+			return v
+		}
+		args := make([]Type, len(v.Args))
+		for i, arg := range v.Args {
+			args[i] = exportType(arg, defaultNum, vars, n)
+		}
+		return &TypeCon{Name: v.Name, Args: args}
+	}
+	panic(t)
+}
+
+// unify makes the two types equal by binding type variables. Want is the type
+// required by the context, and got is the type of the expression at pos.
+func (c *TypeChecker) unify(pos scanner.Position, want, got Type) {
+	if !c.unifyRec(pos, want, got) {
+		n := &typeNamer{}
+		c.errorf(pos, "type mismatch: expect %s, but found %s", typeString(want, n), typeString(got, n))
+	}
+}
+
+func (c *TypeChecker) unifyRec(pos scanner.Position, t0, t1 Type) bool {
+	t0, t1 = prune(t0), prune(t1)
+	if v, ok := t0.(*TypeVar); ok {
+		return c.bindVar(pos, v, t1)
+	}
+	if v, ok := t1.(*TypeVar); ok {
+		return c.bindVar(pos, v, t0)
+	}
+	c0, c1 := t0.(*TypeCon), t1.(*TypeCon)
+	if c0.Name != c1.Name || len(c0.Args) != len(c1.Args) {
+		return false
+	}
+	for i := range c0.Args {
+		if !c.unifyRec(pos, c0.Args[i], c1.Args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *TypeChecker) bindVar(pos scanner.Position, v *TypeVar, t Type) bool {
+	if t == Type(v) {
+		return true
+	}
+	switch t := t.(type) {
+	case *TypeVar:
+		if (v.numeric && !t.numeric) || (v.ordered && !t.ordered && !t.numeric) {
+			// Keep the constraint.
+			return c.bindVar(pos, t, v)
+		}
+	case *TypeCon:
+		if v.numeric && t.Name != tInt.Name && t.Name != tFloat.Name {
+			return false
+		}
+		if v.ordered && !orderedTypes[t.Name] {
+			c.errorf(pos, "type %s cannot be compared", typeString(t, &typeNamer{}))
+		}
+	}
+	if occurs(v, t) {
+		n := &typeNamer{}
+		c.errorf(pos, "infinite type: %s = %s", typeString(v, n), typeString(t, n))
+	}
+	v.link = t
+	return true
+}
+
+// orderedTypes are the types that an ordered type variable can be bound to.
+var orderedTypes = map[string]bool{
+	tInt.Name: true, tFloat.Name: true, tBool.Name: true, tString.Name: true, tChar.Name: true,
+}
+
+// occurs checks if v appears in t. As a side effect, it lowers the levels of the
+// variables in t to v's level, since they become reachable from v.
+func occurs(v *TypeVar, t Type) bool {
+	switch t := prune(t).(type) {
+	case *TypeVar:
+		if t == v {
+			return true
+		}
+		if t.level > v.level {
+			t.level = v.level
+		}
+	case *TypeCon:
+		for _, arg := range t.Args {
+			if occurs(v, arg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseTypeSig parses a type signature such as "a -> a -> Bool". Lowercase
+// identifiers are type variables and are quantified, except that "num" is a
+// numeric variable, which is instantiated to Int or Float, and "ord" is an
+// ordered variable.
+func parseTypeSig(sig string) Type {
+	sc := &scanner.Scanner{}
+	sc.Init(strings.NewReader(sig))
+	sc.Mode = scanner.ScanIdents
+	vars := map[string]*TypeVar{}
+	tok := sc.Scan()
+	next := func() { tok = sc.Scan() }
+
+	var parseType func() Type
+	parseAtom := func() (Type, bool) {
+		switch {
+		case tok == scanner.Ident:
+			name := sc.TokenText()
+			next()
+			if name[0] >= 'a' && name[0] <= 'z' {
+				v, ok := vars[name]
+				if !ok {
+					v = &TypeVar{level: genericLevel, numeric: name == "num", ordered: name == "ord"}
+					vars[name] = v
+				}
+				return v, true
+			}
+			return &TypeCon{Name: name}, true
+		case tok == '(':
+			next()
+			t := parseType()
+			if tok != ')' {
+				panic(sig)
+			}
+			next()
+			return t, true
+		case tok == '[':
+			next()
+			t := parseType()
+			if tok != ']' {
+				panic(sig)
+			}
+			next()
+			return newListType(t), true
+		}
+		return nil, false
+	}
+	parseType = func() Type {
+		t, ok := parseAtom()
+		if !ok {
+			panic(sig)
+		}
+		if con, ok := t.(*TypeCon); ok && len(con.Args) == 0 {
+			for {
+				arg, ok := parseAtom()
+				if !ok {
+					break
+				}
+				con = &TypeCon{Name: con.Name, Args: append(con.Args, arg)}
+			}
+			t = con
+		}
+		if tok == '-' {
+			next()
+			if tok != '>' {
+				panic(sig)
+			}
+			next()
+			return newFuncType(t, parseType())
+		}
+		return t
+	}
+	t := parseType()
+	if tok != scanner.EOF {
+		panic(sig)
+	}
+	return t
+}
+
+// parseTypeSigErr is similar to parseTypeSig, but it returns an error if sig is
+// invalid.
+func parseTypeSigErr(sig string) (t Type, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid type signature %q", sig)
+		}
+	}()
+	return parseTypeSig(sig), nil
+}
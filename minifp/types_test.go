@@ -0,0 +1,108 @@
+package minifp_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/yasushi-saito/minifp/minifp"
+)
+
+// typeOf type-checks a sequence of toplevel expressions and returns the type of
+// the last one.
+func typeOf(t *testing.T, src string) (string, error) {
+	tc := minifp.NewTypeChecker()
+	var (
+		typ minifp.Type
+		err error
+	)
+	for _, node := range minifp.Parse(strings.NewReader(src)) {
+		if typ, err = tc.Check(node); err != nil {
+			return "", err
+		}
+	}
+	return typ.String(), nil
+}
+
+func TestTypeOf(t *testing.T) {
+	for _, test := range []struct{ src, want string }{
+		{`10`, "Int"},
+		{`10 == 11`, "Bool"},
+		{`\x -> x`, "a -> a"},
+		{`\x y -> x`, "a -> b -> a"},
+		{`\f x -> f (f x)`, "(a -> a) -> a -> a"},
+		{`\f g x -> f (g x)`, "(a -> b) -> (c -> a) -> c -> b"},
+		{`\x -> x + 1`, "Int -> Int"},
+		{`\x -> if x 1 2`, "Bool -> Int"},
+		{`letrec id = \x -> x in if (id (1 == 1)) (id 1) 2`, "Int"},
+		{`letrec fact = \n -> if (n==0) 1 (n*fact (n-1)) in fact`, "Int -> Int"},
+		{`letrec even = \n -> if (n==0) (1==1) (odd (n-1)); odd = \n -> if (n==0) (1==0) (even (n-1)) in even`, "Int -> Bool"},
+		{`\x -> letrec y = x in y`, "a -> a"},
+		{`id x = x; twice f x = f (f x); twice id`, "a -> a"},
+		{`compose f g x = f (g x); compose (\x -> x == 1) (\x -> x * 2)`, "Int -> Bool"},
+		{`loop x = loop x; loop`, "a -> b"},
+	} {
+		got, err := typeOf(t, test.src)
+		expect.NoError(t, err, test.src)
+		expect.EQ(t, got, test.want, test.src)
+	}
+}
+
+func TestTypeError(t *testing.T) {
+	for _, test := range []struct{ src, want string }{
+		{`if 1 2 3`, "<input>:1:4: type mismatch: expect Bool, but found Int"},
+		{`if (1 == 1) 2 (1 == 1)`, "<input>:1:16: type mismatch: expect Int, but found Bool"},
+		{`1 + (2 == 3)`, "<input>:1:6: type mismatch: expect Int, but found Bool"},
+		{`(\x -> x + 1) (1 == 1)`, "<input>:1:16: type mismatch: expect Int, but found Bool"},
+		{`1 2`, "<input>:1:1: type mismatch: expect Int -> a, but found Int"},
+		{`\x -> x x`, "<input>:1:7: infinite type: a = a -> b"},
+		{`\f -> if (f 1) (f (1 == 1)) (1 == 1)`, "<input>:1:20: type mismatch: expect Int, but found Bool"},
+		{`x + 1`, "<input>:1:1: variable x not found"},
+	} {
+		_, err := typeOf(t, test.src)
+		var typeErr *minifp.TypeError
+		expect.True(t, errors.As(err, &typeErr), test.src)
+		expect.EQ(t, err.Error(), test.want, test.src)
+	}
+}
+
+func TestCheckDefs(t *testing.T) {
+	tc := minifp.NewTypeChecker()
+	km := minifp.NewMachine()
+	nodes := minifp.Parse(strings.NewReader(`
+main = Pair (isEven (ident 10)) (ident "a");
+isEven n = if (n == 0) (0 == 0) (isOdd (n - 1));
+isOdd n = if (n == 0) (0 == 1) (isEven (n - 1));
+ident x = const x 1;
+const x y = x;
+data Box a = Box a
+`))
+	types, err := tc.CheckDefs(nodes)
+	expect.NoError(t, err)
+	var got []string
+	for _, typ := range types {
+		got = append(got, typ.String())
+	}
+	// Ident is polymorphic in main, since main doesn't belong to its group.
+	expect.EQ(t, got, []string{"Pair Bool String", "num -> Bool", "num -> Bool", "a -> a", "a -> b -> a", "Box a"})
+	expect.NoError(t, km.CompileDefs(nodes))
+	expect.EQ(t, run(t, km, `main`).String(), `Pair true "a"`)
+
+	// On error, the definitions are discarded.
+	for _, test := range []struct{ src, want string }{
+		{"f = 1;\nf = 2", "<input>:2:1: f is defined more than once"},
+		{"f = 1;\nf", "<input>:2:1: toplevel expression f is not a definition"},
+		{"g = h 1;\nh x = x + 1;\nk = h \"a\"", `<input>:3:7: type mismatch: expect Int, but found String`},
+	} {
+		_, err := tc.CheckDefs(minifp.Parse(strings.NewReader(test.src)))
+		expect.EQ(t, err.Error(), test.want, test.src)
+	}
+	_, err = tc.Check(minifp.Parse(strings.NewReader(`g`))[0])
+	expect.HasSubstr(t, err.Error(), "variable g not found")
+
+	err = km.CompileDefs(minifp.Parse(strings.NewReader("g = h 1;\nh x = x + y")))
+	expect.HasSubstr(t, err.Error(), "variable y not found")
+	_, err = km.CompileErr(minifp.Parse(strings.NewReader(`g`))[0])
+	expect.HasSubstr(t, err.Error(), "variable g not found")
+}